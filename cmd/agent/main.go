@@ -32,6 +32,7 @@ func main() {
 	agentID := getenvOr("AGENT_ID", "agent-1")
 	location := getenvOr("AGENT_LOCATION", agentID)
 	country := getenvOr("AGENT_COUNTRY", "unknown")
+	ipDatabases := getenvOr("IP_DATABASE", "")
 
 	brokers := strings.Split(brokersEnv, ",")
 
@@ -42,8 +43,21 @@ func main() {
 		"agentID", agentID,
 	)
 
+	// ---- офлайн-база ASN/геолокации (mmdb или CSV) ----
+	var ipdb *checks.IPDatabase
+	if ipDatabases != "" {
+		db, err := checks.OpenIPDatabase(strings.Split(ipDatabases, ",")...)
+		if err != nil {
+			log.Error("failed to load ip database, continuing without it", "error", err)
+		} else {
+			ipdb = db
+			defer ipdb.Close()
+			log.Info("ip database loaded", "files", ipDatabases)
+		}
+	}
+
 	// ---- регистрируем чекеры ----
-	checkers := buildCheckers(location, country)
+	checkers := buildCheckers(location, country, ipdb)
 	log.Info("checkers registered", "count", len(checkers))
 
 	// ---- Kafka consumer (ТВОЙ РАБОЧИЙ ВАРИАНТ) ----
@@ -68,6 +82,10 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	if ipdb != nil {
+		go reloadOnSighup(ctx, ipdb, log)
+	}
+
 	log.Info("agent started, waiting for tasks...")
 
 	for {
@@ -158,22 +176,49 @@ func main() {
 
 // ---------- helpers ----------
 
-func buildCheckers(location, country string) map[domain.TaskType]Checker {
+func buildCheckers(location, country string, ipdb *checks.IPDatabase) map[domain.TaskType]Checker {
 	checkers := []Checker{
 		checks.NewHTTPChecker(10*time.Second, location, country),
 		checks.NewPingChecker(5*time.Second, 4, location, country),
 		checks.NewTCPChecker(5*time.Second, location, country),
 		checks.NewTracerouteChecker(30, 3*time.Second, location, country),
 		checks.NewDNSChecker(5*time.Second, location, country),
+		checks.NewSSLChecker(10*time.Second, location, country),
+		checks.NewHTTPTransactionChecker(30*time.Second, location, country),
+		checks.NewMTRChecker(30, 2*time.Second, location, country),
+		checks.NewPMTUChecker(time.Second, location, country),
 	}
 
 	m := make(map[domain.TaskType]Checker, len(checkers))
 	for _, c := range checkers {
+		if annotated, ok := c.(interface{ SetIPDatabase(*checks.IPDatabase) }); ok && ipdb != nil {
+			annotated.SetIPDatabase(ipdb)
+		}
 		m[c.Type()] = c
 	}
 	return m
 }
 
+// reloadOnSighup перечитывает базу IP по SIGHUP, например после geoipupdate.
+func reloadOnSighup(ctx context.Context, ipdb *checks.IPDatabase, log *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := ipdb.Reload(); err != nil {
+				log.Error("failed to reload ip database", "error", err)
+				continue
+			}
+			log.Info("ip database reloaded")
+		}
+	}
+}
+
 func sendResult(ctx context.Context, w *kafka.Writer, log *slog.Logger, res domain.CheckResult) error {
 	data, err := json.Marshal(res)
 	if err != nil {
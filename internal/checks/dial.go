@@ -0,0 +1,134 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dialOptions holds the connection-level overrides shared by the HTTP and TCP
+// checkers: curl --resolve style address pinning and IP family selection.
+type dialOptions struct {
+	ipVersion int
+	// overrides maps "host:port" or bare "host" to the IP to connect to.
+	overrides map[string]string
+}
+
+// parseDialOptions reads ip_version and resolve. resolve is either a bare IP,
+// which pins targetHost on any port, or one or more "host:port:addr" entries.
+func parseDialOptions(params map[string]interface{}, targetHost string) (*dialOptions, error) {
+	opts := &dialOptions{
+		ipVersion: intParam(params, "ip_version", 0),
+		overrides: make(map[string]string),
+	}
+	if opts.ipVersion != 0 && opts.ipVersion != 4 && opts.ipVersion != 6 {
+		return nil, fmt.Errorf("ip_version must be 4 or 6")
+	}
+
+	for _, entry := range stringListParam(params, "resolve") {
+		entry = strings.TrimSpace(entry)
+		if ip := net.ParseIP(strings.Trim(entry, "[]")); ip != nil {
+			if targetHost == "" {
+				return nil, fmt.Errorf("resolve: no target host for %s", entry)
+			}
+			opts.overrides[strings.ToLower(targetHost)] = ip.String()
+			continue
+		}
+
+		host, rest, ok := strings.Cut(entry, ":")
+		port, addr, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("resolve: expected host:port:addr, got %s", entry)
+		}
+		ip := net.ParseIP(strings.Trim(addr, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("resolve: invalid address %s", addr)
+		}
+		key := strings.ToLower(host)
+		if port != "" && port != "*" {
+			key = net.JoinHostPort(key, port)
+		}
+		opts.overrides[key] = ip.String()
+	}
+
+	for _, ip := range opts.overrides {
+		if !opts.familyMatches(net.ParseIP(ip)) {
+			return nil, fmt.Errorf("resolve address %s does not match ip_version %d", ip, opts.ipVersion)
+		}
+	}
+
+	return opts, nil
+}
+
+// network narrows "tcp" or "udp" to the configured IP family.
+func (o *dialOptions) network(base string) string {
+	switch o.ipVersion {
+	case 4:
+		return base + "4"
+	case 6:
+		return base + "6"
+	default:
+		return base
+	}
+}
+
+// address applies a resolve override to a "host:port" dial address.
+func (o *dialOptions) address(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	host = strings.ToLower(host)
+	if ip, ok := o.overrides[net.JoinHostPort(host, port)]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+	if ip, ok := o.overrides[host]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+	return addr
+}
+
+// resolve returns an "ip:port" address honouring overrides and the IP family.
+// It is used where the dialer cannot be given a family-specific network.
+func (o *dialOptions) resolve(ctx context.Context, addr string) (string, error) {
+	addr = o.address(addr)
+	if o.ipVersion == 0 {
+		return addr, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return addr, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, o.network("ip"), host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no IPv%d address for %s", o.ipVersion, host)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+func (o *dialOptions) familyMatches(ip net.IP) bool {
+	switch o.ipVersion {
+	case 4:
+		return ip.To4() != nil
+	case 6:
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+func (o *dialOptions) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, o.network(network), o.address(addr))
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"sort"
 	"strings"
 	"time"
 
+	"github.com/miekg/dns"
+
 	"ozzus/agent-aeza/internal/domain"
 )
 
@@ -27,6 +28,10 @@ func NewDNSChecker(timeout time.Duration, location, country string) *DNSChecker
 	}
 }
 
+// Check queries the nameserver given in parameters, or the system ones from
+// resolv.conf with its search domains, and reports every record of the
+// answer with its TTL, class and type, along with the response code, header
+// flags, the server that answered and the round trip time.
 func (d *DNSChecker) Check(target string, parameters map[string]interface{}) (*domain.CheckResult, error) {
 	host, err := normalizeHostname(target)
 	if err != nil {
@@ -34,104 +39,199 @@ func (d *DNSChecker) Check(target string, parameters map[string]interface{}) (*d
 	}
 
 	recordType := strings.ToUpper(stringParam(parameters, "record_type", string(domain.DNSRecordA)))
+	qtype, ok := dns.StringToType[recordType]
+	if !ok || !supportedRecordType(recordType) {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("unsupported record type: %s", recordType)}, nil
+	}
+
+	query, err := dnsQueryParams(parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
 	timeout := durationParam(parameters, "timeout", d.timeout)
 	if timeout <= 0 {
 		timeout = d.timeout
 	}
 
+	// An address is its own A or AAAA record, as with the system resolver.
+	if ip := net.ParseIP(host); ip != nil && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		return d.literalResult(parameters, ip, recordType), nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	resolver := net.Resolver{}
+	// PTR records of an address live under in-addr.arpa or ip6.arpa.
+	name := host
+	if qtype == dns.TypePTR && net.ParseIP(host) != nil {
+		if name, err = dns.ReverseAddr(host); err != nil {
+			return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+		}
+	}
 
-	records, err := d.lookupRecords(ctx, resolver, host, recordType)
+	names, err := query.searchList(name)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+	reply, name, err := query.resolveSearch(ctx, names, qtype)
 	if err != nil {
 		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
 	}
+	msg := reply.msg
+
+	chain := cnameChain(dns.Fqdn(name), msg.Answer)
+	if qtype == dns.TypeCNAME {
+		chain = query.followCNAMEs(ctx, chain)
+	}
+
+	// ttl is the shortest TTL among the requested records, the time until
+	// the whole answer expires from caches.
+	var minTTL uint32
+	found := 0
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		if found == 0 || rr.Header().Ttl < minTTL {
+			minTTL = rr.Header().Ttl
+		}
+		found++
+	}
+
+	rcode := dns.RcodeToString[msg.Rcode]
+	status := domain.StatusSuccess
+	var errText string
+	switch {
+	case msg.Rcode != dns.RcodeSuccess:
+		errText = rcode
+	case found == 0:
+		errText = fmt.Sprintf("no %s records", recordType)
+	}
+	if errText != "" {
+		status = domain.StatusFailed
+	}
+
+	entry := map[string]interface{}{
+		"location":   d.locationValue(parameters),
+		"country":    d.countryValue(parameters),
+		"query":      dnsName(dns.Fqdn(name)),
+		"type":       recordType,
+		"records":    d.annotateAddresses(recordsPayload(msg.Answer)),
+		"authority":  d.annotateAddresses(recordsPayload(msg.Ns)),
+		"additional": d.annotateAddresses(recordsPayload(msg.Extra)),
+		"ttl":        formatTTL(time.Duration(minTTL) * time.Second),
+		"nameserver": reply.server,
+		"transport":  reply.transport,
+		"rcode":      rcode,
+		"flags": map[string]interface{}{
+			"aa": msg.Authoritative,
+			"tc": msg.Truncated,
+			"rd": msg.RecursionDesired,
+			"ra": msg.RecursionAvailable,
+		},
+		"rtt": durationMillis(reply.rtt),
+	}
+	if opt := msg.IsEdns0(); opt != nil {
+		entry["ednsBufferSize"] = int(opt.UDPSize())
+	}
+	if len(chain) > 0 {
+		entry["chain"] = chainPayload(chain)
+	}
 
 	payload := map[string]interface{}{
 		"dns": map[string]interface{}{
-			"locations": []map[string]interface{}{
-				{
-					"location": d.locationValue(parameters),
-					"country":  d.countryValue(parameters),
-					"records":  strings.Join(records, ", "),
-					"ttl":      "N/A",
-				},
-			},
+			"locations": []map[string]interface{}{entry},
 		},
 	}
 
 	return &domain.CheckResult{
-		Status:  domain.StatusSuccess,
+		Status:  status,
+		Error:   errText,
 		Payload: payload,
 	}, nil
 }
 
-func (d *DNSChecker) lookupRecords(ctx context.Context, resolver net.Resolver, target, recordType string) ([]string, error) {
-	switch domain.DNSRecordType(recordType) {
-	case domain.DNSRecordA:
-		addrs, err := resolver.LookupIPAddr(ctx, target)
-		if err != nil {
-			return nil, err
-		}
-		return d.formatIPAddrs(addrs, false), nil
-	case domain.DNSRecordAAAA:
-		addrs, err := resolver.LookupIPAddr(ctx, target)
-		if err != nil {
-			return nil, err
-		}
-		return d.formatIPAddrs(addrs, true), nil
-	case domain.DNSRecordMX:
-		mx, err := resolver.LookupMX(ctx, target)
-		if err != nil {
-			return nil, err
-		}
-		sort.Slice(mx, func(i, j int) bool { return mx[i].Pref < mx[j].Pref })
-		results := make([]string, 0, len(mx))
-		for _, entry := range mx {
-			results = append(results, fmt.Sprintf("%d %s", entry.Pref, strings.TrimSuffix(entry.Host, ".")))
-		}
-		return results, nil
-	case domain.DNSRecordNS:
-		ns, err := resolver.LookupNS(ctx, target)
-		if err != nil {
-			return nil, err
-		}
-		results := make([]string, 0, len(ns))
-		for _, entry := range ns {
-			results = append(results, strings.TrimSuffix(entry.Host, "."))
-		}
-		return results, nil
-	case domain.DNSRecordTXT:
-		txt, err := resolver.LookupTXT(ctx, target)
-		if err != nil {
-			return nil, err
-		}
-		return txt, nil
-	default:
-		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+// literalResult answers an A or AAAA check of an IP address target without
+// sending a query.
+func (d *DNSChecker) literalResult(parameters map[string]interface{}, ip net.IP, recordType string) *domain.CheckResult {
+	var rr dns.RR
+	header := dns.RR_Header{Name: dns.Fqdn(ip.String()), Class: dns.ClassINET}
+	if ip4 := ip.To4(); ip4 != nil && recordType == string(domain.DNSRecordA) {
+		header.Rrtype = dns.TypeA
+		rr = &dns.A{Hdr: header, A: ip4}
+	} else if ip4 == nil && recordType == string(domain.DNSRecordAAAA) {
+		header.Rrtype = dns.TypeAAAA
+		rr = &dns.AAAA{Hdr: header, AAAA: ip}
+	}
+
+	status := domain.StatusSuccess
+	var errText string
+	var records []dns.RR
+	if rr != nil {
+		records = append(records, rr)
+	} else {
+		status = domain.StatusFailed
+		errText = fmt.Sprintf("no %s records", recordType)
+	}
+
+	// The keys match a wire answer; nothing was sent, so there is no
+	// nameserver, transport or round trip and no flags are set.
+	entry := map[string]interface{}{
+		"location":   d.locationValue(parameters),
+		"country":    d.countryValue(parameters),
+		"query":      dnsName(dns.Fqdn(ip.String())),
+		"type":       recordType,
+		"records":    d.annotateAddresses(recordsPayload(records)),
+		"authority":  []map[string]interface{}{},
+		"additional": []map[string]interface{}{},
+		"ttl":        formatTTL(0),
+		"nameserver": "",
+		"transport":  "",
+		"rcode":      dns.RcodeToString[dns.RcodeSuccess],
+		"flags": map[string]interface{}{
+			"aa": false,
+			"tc": false,
+			"rd": false,
+			"ra": false,
+		},
+		"rtt":     durationMillis(0),
+		"literal": true,
+	}
+
+	return &domain.CheckResult{
+		Status: status,
+		Error:  errText,
+		Payload: map[string]interface{}{
+			"dns": map[string]interface{}{
+				"locations": []map[string]interface{}{entry},
+			},
+		},
 	}
 }
 
-func (d *DNSChecker) formatIPAddrs(addrs []net.IPAddr, ipv6 bool) []string {
-	results := make([]string, 0, len(addrs))
-	for _, addr := range addrs {
-		ip := addr.IP
-		if ipv6 && ip.To4() != nil {
+// annotateAddresses adds ipInfo to the A and AAAA records among records.
+func (d *DNSChecker) annotateAddresses(records []map[string]interface{}) []map[string]interface{} {
+	for _, record := range records {
+		address, ok := record["address"].(string)
+		if !ok {
 			continue
 		}
-		if !ipv6 && ip.To4() == nil {
-			continue
+		if info := d.ipInfo(address); info != nil {
+			record["ipInfo"] = info
 		}
-		results = append(results, ip.String())
 	}
-	if len(results) == 0 {
-		for _, addr := range addrs {
-			results = append(results, addr.IP.String())
-		}
+	return records
+}
+
+func supportedRecordType(recordType string) bool {
+	switch domain.DNSRecordType(recordType) {
+	case domain.DNSRecordA, domain.DNSRecordAAAA, domain.DNSRecordMX, domain.DNSRecordNS, domain.DNSRecordTXT,
+		domain.DNSRecordCNAME, domain.DNSRecordSOA, domain.DNSRecordSRV, domain.DNSRecordCAA, domain.DNSRecordPTR,
+		domain.DNSRecordDS, domain.DNSRecordDNSKEY, domain.DNSRecordHTTPS, domain.DNSRecordSVCB:
+		return true
 	}
-	return results
+	return false
 }
 
 func (d *DNSChecker) Type() domain.TaskType {
@@ -0,0 +1,247 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsDefaultEDNSSize = 1232
+	dnsResolvConf      = "/etc/resolv.conf"
+	// dnsMaxCNAMEs bounds the chain followed for CNAME queries.
+	dnsMaxCNAMEs = 16
+)
+
+// dnsQuery describes how queries are sent: to the given nameserver, or to
+// the ones in resolv.conf when it is empty.
+type dnsQuery struct {
+	nameserver string
+	// transport is udp or tcp. Empty means UDP with a retry over TCP when
+	// the answer is truncated.
+	transport string
+	recursion bool
+	// ednsSize is the advertised EDNS buffer size, 0 disables EDNS.
+	ednsSize int
+}
+
+// dnsReply is a response with the server and transport that delivered it.
+type dnsReply struct {
+	msg       *dns.Msg
+	server    string
+	transport string
+	rtt       time.Duration
+}
+
+// dnsQueryParams reads nameserver, transport, recursion_desired and
+// edns_buffer_size. The nameserver port defaults to 53.
+func dnsQueryParams(parameters map[string]interface{}) (dnsQuery, error) {
+	query := dnsQuery{
+		nameserver: stringParam(parameters, "nameserver", ""),
+		transport:  lowerStringParam(parameters, "transport", ""),
+		recursion:  boolParam(parameters, "recursion_desired", true),
+		ednsSize:   intParam(parameters, "edns_buffer_size", dnsDefaultEDNSSize),
+	}
+
+	if query.nameserver != "" {
+		query.nameserver = nameserverAddress(query.nameserver, "53")
+	}
+	if query.transport != "" && query.transport != "udp" && query.transport != "tcp" {
+		return query, fmt.Errorf("unsupported transport: %s", query.transport)
+	}
+	if query.ednsSize != 0 && (query.ednsSize < dns.MinMsgSize || query.ednsSize > dns.MaxMsgSize) {
+		return query, fmt.Errorf("edns_buffer_size must be 0 or between %d and %d", dns.MinMsgSize, dns.MaxMsgSize)
+	}
+	return query, nil
+}
+
+func nameserverAddress(server, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// nameservers returns the servers to ask in order.
+func (q dnsQuery) nameservers() ([]string, error) {
+	if q.nameserver != "" {
+		return []string{q.nameserver}, nil
+	}
+
+	config, err := systemConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Servers) == 0 {
+		return nil, fmt.Errorf("system nameservers: none in %s", dnsResolvConf)
+	}
+
+	servers := make([]string, 0, len(config.Servers))
+	for _, server := range config.Servers {
+		servers = append(servers, nameserverAddress(server, config.Port))
+	}
+	return servers, nil
+}
+
+func systemConfig() (*dns.ClientConfig, error) {
+	config, err := dns.ClientConfigFromFile(dnsResolvConf)
+	if err != nil {
+		return nil, fmt.Errorf("system nameservers: %w", err)
+	}
+	return config, nil
+}
+
+// searchList returns the names to query for name. With an explicit
+// nameserver that is the name alone; otherwise the search domains and ndots
+// of resolv.conf apply, as with the system resolver.
+func (q dnsQuery) searchList(name string) ([]string, error) {
+	if q.nameserver != "" || dns.IsFqdn(name) {
+		return []string{dns.Fqdn(name)}, nil
+	}
+
+	config, err := systemConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.NameList(name), nil
+}
+
+// resolveSearch queries names in order and returns the first answer with
+// records of qtype, and the name it is for. Without one, the first answer
+// other than NXDOMAIN is returned, or else the last.
+func (q dnsQuery) resolveSearch(ctx context.Context, names []string, qtype uint16) (dnsReply, string, error) {
+	var fallback dnsReply
+	var fallbackName string
+	for _, name := range names {
+		reply, err := q.resolve(ctx, name, qtype)
+		if err != nil {
+			return dnsReply{}, name, err
+		}
+		if reply.msg.Rcode == dns.RcodeSuccess {
+			for _, rr := range reply.msg.Answer {
+				if rr.Header().Rrtype == qtype {
+					return reply, name, nil
+				}
+			}
+		}
+		if fallback.msg == nil || fallback.msg.Rcode == dns.RcodeNameError {
+			fallback, fallbackName = reply, name
+		}
+	}
+	return fallback, fallbackName, nil
+}
+
+// resolve asks the nameservers one after another until one of them
+// answers, each within its share of the remaining time. The name is always
+// queried as fully qualified.
+func (q dnsQuery) resolve(ctx context.Context, name string, qtype uint16) (dnsReply, error) {
+	servers, err := q.nameservers()
+	if err != nil {
+		return dnsReply{}, err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = q.recursion
+	if q.ednsSize > 0 {
+		msg.SetEdns0(uint16(q.ednsSize), false)
+	}
+
+	var errs []error
+	for i, server := range servers {
+		// The time left is shared among the servers not asked yet, so one
+		// that is down does not use up the whole check timeout.
+		serverCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok && i < len(servers)-1 {
+			serverCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(servers)-i))
+		}
+		reply, err := q.exchange(serverCtx, msg, server)
+		cancel()
+		if err == nil {
+			return reply, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return dnsReply{}, errors.Join(errs...)
+}
+
+// exchange sends msg to one server. Without an explicit transport a
+// truncated UDP answer is asked again over TCP.
+func (q dnsQuery) exchange(ctx context.Context, msg *dns.Msg, server string) (dnsReply, error) {
+	transport := q.transport
+	if transport == "" {
+		transport = "udp"
+	}
+
+	reply, err := exchangeOver(ctx, msg, server, transport)
+	if err == nil && q.transport == "" && reply.msg.Truncated {
+		return exchangeOver(ctx, msg, server, "tcp")
+	}
+	return reply, err
+}
+
+func exchangeOver(ctx context.Context, msg *dns.Msg, server, transport string) (dnsReply, error) {
+	client := &dns.Client{Net: transport}
+	if deadline, ok := ctx.Deadline(); ok {
+		client.Timeout = time.Until(deadline)
+	}
+
+	conn, err := client.DialContext(ctx, server)
+	if err != nil {
+		return dnsReply{}, err
+	}
+	defer conn.Close()
+
+	reply, rtt, err := client.ExchangeWithConnContext(ctx, msg, conn)
+	if err != nil {
+		return dnsReply{}, err
+	}
+	return dnsReply{
+		msg:       reply,
+		server:    conn.RemoteAddr().String(),
+		transport: transport,
+		rtt:       rtt,
+	}, nil
+}
+
+// followCNAMEs extends chain with further CNAME queries for its last
+// target. Resolvers answer a CNAME query with the first link only, the
+// answers of other types already contain the whole chain.
+func (q dnsQuery) followCNAMEs(ctx context.Context, chain []*dns.CNAME) []*dns.CNAME {
+	seen := make(map[string]bool)
+	for _, cname := range chain {
+		seen[strings.ToLower(cname.Hdr.Name)] = true
+	}
+
+	for len(chain) > 0 && len(chain) < dnsMaxCNAMEs {
+		target := chain[len(chain)-1].Target
+		if seen[strings.ToLower(target)] {
+			break
+		}
+
+		reply, err := q.resolve(ctx, target, dns.TypeCNAME)
+		if err != nil || reply.msg.Rcode != dns.RcodeSuccess {
+			break
+		}
+		next := cnameChain(target, reply.msg.Answer)
+		if len(next) == 0 {
+			break
+		}
+		for _, cname := range next {
+			if seen[strings.ToLower(cname.Hdr.Name)] || len(chain) >= dnsMaxCNAMEs {
+				return chain
+			}
+			seen[strings.ToLower(cname.Hdr.Name)] = true
+			chain = append(chain, cname)
+		}
+	}
+	return chain
+}
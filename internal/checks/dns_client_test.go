@@ -0,0 +1,88 @@
+package checks
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolveSearchFallback(t *testing.T) {
+	// Names under nx. do not exist, those under empty. exist without A
+	// records and those under a. have one.
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(r)
+		name := r.Question[0].Name
+		switch dns.SplitDomainName(name)[1] {
+		case "nx":
+			reply.Rcode = dns.RcodeNameError
+		case "a":
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+		}
+		w.WriteMsg(reply)
+	})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	<-started
+
+	tests := []struct {
+		name      string
+		names     []string
+		wantName  string
+		wantRcode int
+	}{
+		{
+			name:      "answer wins over earlier no data",
+			names:     []string{"host.empty.", "host.a."},
+			wantName:  "host.a.",
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "no data preferred over later nxdomain",
+			names:     []string{"host.empty.", "host.nx."},
+			wantName:  "host.empty.",
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "no data replaces earlier nxdomain",
+			names:     []string{"host.nx.", "host.empty.", "other.empty."},
+			wantName:  "host.empty.",
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "last nxdomain when nothing exists",
+			names:     []string{"host.nx.", "other.nx."},
+			wantName:  "other.nx.",
+			wantRcode: dns.RcodeNameError,
+		},
+	}
+
+	query := dnsQuery{nameserver: conn.LocalAddr().String(), transport: "udp"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			reply, name, err := query.resolveSearch(ctx, tt.names, dns.TypeA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.wantName || reply.msg.Rcode != tt.wantRcode {
+				t.Fatalf("got %s with %s, want %s with %s", name, dns.RcodeToString[reply.msg.Rcode], tt.wantName, dns.RcodeToString[tt.wantRcode])
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package checks
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// recordPayload describes one resource record. value is the record data in
+// zone file syntax, the type-specific fields carry the same data parsed.
+func recordPayload(rr dns.RR) map[string]interface{} {
+	header := rr.Header()
+	entry := map[string]interface{}{
+		"name":  dnsName(header.Name),
+		"type":  dns.Type(header.Rrtype).String(),
+		"class": dns.Class(header.Class).String(),
+		"ttl":   header.Ttl,
+		"value": strings.TrimPrefix(rr.String(), header.String()),
+	}
+
+	switch record := rr.(type) {
+	case *dns.A:
+		entry["address"] = record.A.String()
+	case *dns.AAAA:
+		entry["address"] = record.AAAA.String()
+	case *dns.CNAME:
+		entry["target"] = dnsName(record.Target)
+	case *dns.MX:
+		entry["preference"] = record.Preference
+		entry["exchange"] = dnsName(record.Mx)
+	case *dns.NS:
+		entry["host"] = dnsName(record.Ns)
+	case *dns.TXT:
+		entry["text"] = strings.Join(record.Txt, "")
+		entry["strings"] = record.Txt
+	case *dns.PTR:
+		entry["target"] = dnsName(record.Ptr)
+	case *dns.SOA:
+		entry["mname"] = dnsName(record.Ns)
+		entry["rname"] = dnsName(record.Mbox)
+		entry["serial"] = record.Serial
+		entry["refresh"] = record.Refresh
+		entry["retry"] = record.Retry
+		entry["expire"] = record.Expire
+		entry["minimum"] = record.Minttl
+	case *dns.SRV:
+		entry["priority"] = record.Priority
+		entry["weight"] = record.Weight
+		entry["port"] = record.Port
+		entry["target"] = dnsName(record.Target)
+	case *dns.CAA:
+		entry["flag"] = record.Flag
+		entry["critical"] = record.Flag&0x80 != 0
+		entry["tag"] = record.Tag
+		entry["content"] = record.Value
+	case *dns.DS:
+		entry["keyTag"] = record.KeyTag
+		entry["algorithm"] = record.Algorithm
+		entry["algorithmName"] = dns.AlgorithmToString[record.Algorithm]
+		entry["digestType"] = record.DigestType
+		entry["digestTypeName"] = dns.HashToString[record.DigestType]
+		entry["digest"] = strings.ToLower(record.Digest)
+	case *dns.DNSKEY:
+		entry["flags"] = record.Flags
+		// 257 marks a key signing key, 256 a zone signing key.
+		entry["secureEntryPoint"] = record.Flags&dns.SEP != 0
+		entry["zoneKey"] = record.Flags&dns.ZONE != 0
+		entry["protocol"] = record.Protocol
+		entry["algorithm"] = record.Algorithm
+		entry["algorithmName"] = dns.AlgorithmToString[record.Algorithm]
+		entry["keyTag"] = record.KeyTag()
+		entry["publicKey"] = record.PublicKey
+	case *dns.HTTPS:
+		svcbFields(entry, &record.SVCB)
+	case *dns.SVCB:
+		svcbFields(entry, record)
+	}
+	return entry
+}
+
+// svcbFields describes an HTTPS or SVCB record. Priority 0 is alias mode,
+// where target names another service; otherwise params hold the SvcParams
+// such as alpn, port and ipv4hint in presentation format.
+func svcbFields(entry map[string]interface{}, record *dns.SVCB) {
+	mode := "service"
+	if record.Priority == 0 {
+		mode = "alias"
+	}
+	params := make(map[string]interface{}, len(record.Value))
+	for _, kv := range record.Value {
+		params[kv.Key().String()] = kv.String()
+	}
+
+	entry["priority"] = record.Priority
+	entry["mode"] = mode
+	entry["target"] = dnsName(record.Target)
+	entry["params"] = params
+}
+
+// cnameChain follows the CNAME records in rrs from name and returns them in
+// order. A loop ends the chain.
+func cnameChain(name string, rrs []dns.RR) []*dns.CNAME {
+	targets := make(map[string]*dns.CNAME)
+	for _, rr := range rrs {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			targets[strings.ToLower(cname.Hdr.Name)] = cname
+		}
+	}
+
+	var chain []*dns.CNAME
+	seen := make(map[string]bool)
+	for {
+		key := strings.ToLower(name)
+		cname, ok := targets[key]
+		if !ok || seen[key] {
+			return chain
+		}
+		seen[key] = true
+		chain = append(chain, cname)
+		name = cname.Target
+	}
+}
+
+func chainPayload(chain []*dns.CNAME) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(chain))
+	for _, cname := range chain {
+		result = append(result, map[string]interface{}{
+			"name":   dnsName(cname.Hdr.Name),
+			"target": dnsName(cname.Target),
+			"ttl":    cname.Hdr.Ttl,
+		})
+	}
+	return result
+}
+
+// recordsPayload describes a message section. OPT pseudo records are left
+// out, their data is reported separately.
+func recordsPayload(rrs []dns.RR) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		result = append(result, recordPayload(rr))
+	}
+	return result
+}
+
+// dnsName strips the trailing dot of a fully qualified name, except for the
+// root.
+func dnsName(name string) string {
+	if name == "." {
+		return name
+	}
+	return strings.TrimSuffix(name, ".")
+}
@@ -0,0 +1,152 @@
+package checks
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return rr
+}
+
+func TestCNAMEChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   string
+		records []string
+		want    []string
+	}{
+		{
+			name:  "in order regardless of answer order",
+			start: "www.example.com.",
+			records: []string{
+				"cdn.example.net. 60 IN CNAME edge.example.org.",
+				"www.example.com. 300 IN CNAME cdn.example.net.",
+				"edge.example.org. 60 IN A 192.0.2.1",
+			},
+			want: []string{"www.example.com", "cdn.example.net"},
+		},
+		{
+			name:    "case insensitive",
+			start:   "WWW.Example.com.",
+			records: []string{"www.example.com. 300 IN CNAME cdn.example.net."},
+			want:    []string{"www.example.com"},
+		},
+		{
+			name:  "loop cut off",
+			start: "a.example.com.",
+			records: []string{
+				"a.example.com. 60 IN CNAME b.example.com.",
+				"b.example.com. 60 IN CNAME a.example.com.",
+			},
+			want: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:    "no cname",
+			start:   "example.com.",
+			records: []string{"example.com. 60 IN A 192.0.2.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rrs []dns.RR
+			for _, record := range tt.records {
+				rrs = append(rrs, mustRR(t, record))
+			}
+
+			var got []string
+			for _, cname := range cnameChain(tt.start, rrs) {
+				got = append(got, dnsName(cname.Hdr.Name))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "SOA",
+			record: "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 900 1209600 300",
+			want: map[string]interface{}{
+				"mname":   "ns1.example.com",
+				"rname":   "hostmaster.example.com",
+				"serial":  uint32(2024010101),
+				"refresh": uint32(7200),
+				"retry":   uint32(900),
+				"expire":  uint32(1209600),
+				"minimum": uint32(300),
+			},
+		},
+		{
+			name:   "CAA",
+			record: `example.com. 3600 IN CAA 128 issue "letsencrypt.org"`,
+			want: map[string]interface{}{
+				"flag":     uint8(128),
+				"critical": true,
+				"tag":      "issue",
+				"content":  "letsencrypt.org",
+			},
+		},
+		{
+			name:   "DS",
+			record: "example.com. 3600 IN DS 2371 13 2 C988EC423E3880EB8DD8A46FE06CA230EE23F35B578D65D2E4E1D1F5A2A6C3F2",
+			want: map[string]interface{}{
+				"keyTag":         uint16(2371),
+				"algorithm":      uint8(13),
+				"algorithmName":  "ECDSAP256SHA256",
+				"digestType":     uint8(2),
+				"digestTypeName": "SHA256",
+				"digest":         "c988ec423e3880eb8dd8a46fe06ca230ee23f35b578d65d2e4e1d1f5a2a6c3f2",
+			},
+		},
+		{
+			name:   "HTTPS service",
+			record: `example.com. 300 IN HTTPS 1 . alpn="h2,h3" port=443`,
+			want: map[string]interface{}{
+				"priority": uint16(1),
+				"mode":     "service",
+				"target":   ".",
+				"params":   map[string]interface{}{"alpn": "h2,h3", "port": "443"},
+			},
+		},
+		{
+			name:   "HTTPS alias",
+			record: "example.com. 300 IN HTTPS 0 cdn.example.net.",
+			want: map[string]interface{}{
+				"priority": uint16(0),
+				"mode":     "alias",
+				"target":   "cdn.example.net",
+				"params":   map[string]interface{}{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := recordPayload(mustRR(t, tt.record))
+			if entry["name"] != "example.com" || entry["class"] != "IN" {
+				t.Fatalf("unexpected header fields: %v", entry)
+			}
+			for key, want := range tt.want {
+				if !reflect.DeepEqual(entry[key], want) {
+					t.Errorf("%s: got %#v, want %#v", key, entry[key], want)
+				}
+			}
+		})
+	}
+}
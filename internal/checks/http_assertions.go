@@ -0,0 +1,344 @@
+package checks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type statusMatcher struct {
+	min, max int
+	text     string
+}
+
+type jsonPathAssertion struct {
+	path     string
+	expected interface{}
+	exists   bool // only check that the path resolves
+}
+
+type headerAssertion struct {
+	name     string
+	contains string
+}
+
+type httpAssertions struct {
+	statuses         []statusMatcher
+	bodyContains     []string
+	bodyNotContains  []string
+	bodyRegex        []*regexp.Regexp
+	bodyNotRegex     []*regexp.Regexp
+	jsonPaths        []jsonPathAssertion
+	headers          []headerAssertion
+	forbiddenHeaders []string
+}
+
+type assertionOutcome struct {
+	kind     string
+	target   string
+	expected interface{}
+	actual   interface{}
+	passed   bool
+}
+
+func parseHTTPAssertions(params map[string]interface{}) (*httpAssertions, error) {
+	a := &httpAssertions{
+		bodyContains:     stringListParam(params, "body_contains"),
+		bodyNotContains:  stringListParam(params, "body_not_contains"),
+		forbiddenHeaders: stringListParam(params, "forbidden_headers"),
+	}
+
+	for _, raw := range stringListParam(params, "expected_status") {
+		matcher, err := parseStatusMatcher(raw)
+		if err != nil {
+			return nil, err
+		}
+		a.statuses = append(a.statuses, matcher)
+	}
+
+	var err error
+	if a.bodyRegex, err = compileRegexps(stringListParam(params, "body_regex")); err != nil {
+		return nil, err
+	}
+	if a.bodyNotRegex, err = compileRegexps(stringListParam(params, "body_not_regex")); err != nil {
+		return nil, err
+	}
+
+	if a.jsonPaths, err = parseJSONPathAssertions(params["json_path"]); err != nil {
+		return nil, err
+	}
+
+	for name, value := range mapParam(params, "expected_headers") {
+		a.headers = append(a.headers, headerAssertion{name: name, contains: fmt.Sprintf("%v", value)})
+	}
+	sort.Slice(a.headers, func(i, j int) bool { return a.headers[i].name < a.headers[j].name })
+
+	return a, nil
+}
+
+func parseStatusMatcher(raw string) (statusMatcher, error) {
+	text := strings.ToLower(strings.TrimSpace(raw))
+
+	if len(text) == 3 && strings.HasSuffix(text, "xx") {
+		class, err := strconv.Atoi(text[:1])
+		if err != nil {
+			return statusMatcher{}, fmt.Errorf("invalid status class: %s", raw)
+		}
+		return statusMatcher{min: class * 100, max: class*100 + 99, text: text}, nil
+	}
+
+	if from, to, ok := strings.Cut(text, "-"); ok {
+		lo, errLo := strconv.Atoi(strings.TrimSpace(from))
+		hi, errHi := strconv.Atoi(strings.TrimSpace(to))
+		if errLo != nil || errHi != nil || lo > hi {
+			return statusMatcher{}, fmt.Errorf("invalid status range: %s", raw)
+		}
+		return statusMatcher{min: lo, max: hi, text: text}, nil
+	}
+
+	code, err := strconv.Atoi(text)
+	if err != nil {
+		return statusMatcher{}, fmt.Errorf("invalid status code: %s", raw)
+	}
+	return statusMatcher{min: code, max: code, text: text}, nil
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// parseJSONPathAssertions accepts either a map of path to expected value or a
+// list of {"path": ..., "value": ...} objects; entries without a value only
+// require the path to exist.
+func parseJSONPathAssertions(raw interface{}) ([]jsonPathAssertion, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		result := make([]jsonPathAssertion, 0, len(v))
+		for path, expected := range v {
+			result = append(result, jsonPathAssertion{path: path, expected: expected})
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].path < result[j].path })
+		return result, nil
+	case []interface{}:
+		result := make([]jsonPathAssertion, 0, len(v))
+		for _, item := range v {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid json_path entry: %v", item)
+			}
+			path := stringParam(entry, "path", "")
+			if path == "" {
+				return nil, fmt.Errorf("json_path entry without path")
+			}
+			expected, hasValue := entry["value"]
+			result = append(result, jsonPathAssertion{path: path, expected: expected, exists: !hasValue})
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("invalid json_path parameter")
+	}
+}
+
+func (a *httpAssertions) hasStatus() bool {
+	return len(a.statuses) > 0
+}
+
+func (a *httpAssertions) needsBody() bool {
+	return len(a.bodyContains) > 0 || len(a.bodyNotContains) > 0 ||
+		len(a.bodyRegex) > 0 || len(a.bodyNotRegex) > 0 || len(a.jsonPaths) > 0
+}
+
+func (a *httpAssertions) empty() bool {
+	return !a.hasStatus() && !a.needsBody() && len(a.headers) == 0 && len(a.forbiddenHeaders) == 0
+}
+
+func (a *httpAssertions) evaluate(resp *http.Response, body []byte) []assertionOutcome {
+	var outcomes []assertionOutcome
+
+	if a.hasStatus() {
+		expected := make([]string, 0, len(a.statuses))
+		passed := false
+		for _, matcher := range a.statuses {
+			expected = append(expected, matcher.text)
+			if resp.StatusCode >= matcher.min && resp.StatusCode <= matcher.max {
+				passed = true
+			}
+		}
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "status", expected: expected, actual: resp.StatusCode, passed: passed,
+		})
+	}
+
+	text := string(body)
+	for _, needle := range a.bodyContains {
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "body_contains", expected: needle, passed: strings.Contains(text, needle),
+		})
+	}
+	for _, needle := range a.bodyNotContains {
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "body_not_contains", expected: needle, passed: !strings.Contains(text, needle),
+		})
+	}
+	for _, re := range a.bodyRegex {
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "body_regex", expected: re.String(), passed: re.Match(body),
+		})
+	}
+	for _, re := range a.bodyNotRegex {
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "body_not_regex", expected: re.String(), passed: !re.Match(body),
+		})
+	}
+
+	if len(a.jsonPaths) > 0 {
+		var document interface{}
+		decodeErr := json.Unmarshal(body, &document)
+		for _, check := range a.jsonPaths {
+			outcome := assertionOutcome{kind: "json_path", target: check.path, expected: check.expected}
+			if decodeErr != nil {
+				outcome.actual = "invalid json: " + decodeErr.Error()
+			} else if value, err := evalJSONPath(document, check.path); err != nil {
+				outcome.actual = err.Error()
+			} else {
+				outcome.actual = value
+				outcome.passed = check.exists || reflect.DeepEqual(value, check.expected)
+			}
+			outcomes = append(outcomes, outcome)
+		}
+	}
+
+	for _, check := range a.headers {
+		values := resp.Header.Values(check.name)
+		actual := strings.Join(values, ", ")
+		passed := len(values) > 0 && strings.Contains(strings.ToLower(actual), strings.ToLower(check.contains))
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "header", target: check.name, expected: check.contains, actual: actual, passed: passed,
+		})
+	}
+	for _, name := range a.forbiddenHeaders {
+		actual := strings.Join(resp.Header.Values(name), ", ")
+		outcomes = append(outcomes, assertionOutcome{
+			kind: "header_absent", target: name, actual: actual, passed: len(resp.Header.Values(name)) == 0,
+		})
+	}
+
+	return outcomes
+}
+
+func assertionsPayload(outcomes []assertionOutcome) ([]map[string]interface{}, string) {
+	result := make([]map[string]interface{}, 0, len(outcomes))
+	var firstFailure string
+	for _, outcome := range outcomes {
+		entry := map[string]interface{}{
+			"type":     outcome.kind,
+			"expected": outcome.expected,
+			"passed":   outcome.passed,
+		}
+		if outcome.target != "" {
+			entry["target"] = outcome.target
+		}
+		if outcome.actual != nil {
+			entry["actual"] = outcome.actual
+		}
+		result = append(result, entry)
+
+		if !outcome.passed && firstFailure == "" {
+			firstFailure = fmt.Sprintf("assertion %s failed", outcome.kind)
+			if outcome.target != "" {
+				firstFailure = fmt.Sprintf("assertion %s %s failed", outcome.kind, outcome.target)
+			}
+		}
+	}
+	return result, firstFailure
+}
+
+// evalJSONPath resolves a simple JSONPath expression such as $.data.items[0].name
+// or $['key'] against a decoded JSON document.
+func evalJSONPath(document interface{}, path string) (interface{}, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path must start with $: %s", path)
+	}
+
+	current := document
+	rest := path[1:]
+	for rest != "" {
+		var key string
+		index := -1
+
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+			if key == "" {
+				return nil, fmt.Errorf("empty segment in json path: %s", path)
+			}
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in json path: %s", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if unquoted, ok := trimQuotes(inner); ok {
+				key = unquoted
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q in json path", inner)
+				}
+				index = n
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q in json path: %s", rest[0], path)
+		}
+
+		if index >= 0 {
+			list, ok := current.([]interface{})
+			if !ok || index >= len(list) {
+				return nil, fmt.Errorf("index %d not found", index)
+			}
+			current = list[index]
+			continue
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", key)
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", key)
+		}
+		current = value
+	}
+
+	return current, nil
+}
+
+func trimQuotes(s string) (string, bool) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1], true
+	}
+	return s, false
+}
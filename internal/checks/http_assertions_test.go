@@ -0,0 +1,99 @@
+package checks
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseStatusMatcher(t *testing.T) {
+	tests := []struct {
+		raw      string
+		min, max int
+		wantErr  bool
+	}{
+		{raw: "200", min: 200, max: 200},
+		{raw: " 404 ", min: 404, max: 404},
+		{raw: "2xx", min: 200, max: 299},
+		{raw: "5XX", min: 500, max: 599},
+		{raw: "200-204", min: 200, max: 204},
+		{raw: "300 - 399", min: 300, max: 399},
+		{raw: "204-200", wantErr: true},
+		{raw: "axx", wantErr: true},
+		{raw: "2x", wantErr: true},
+		{raw: "ok", wantErr: true},
+		{raw: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			matcher, err := parseStatusMatcher(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", matcher)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if matcher.min != tt.min || matcher.max != tt.max {
+				t.Fatalf("got %d-%d, want %d-%d", matcher.min, matcher.max, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestEvalJSONPath(t *testing.T) {
+	var document interface{}
+	err := json.Unmarshal([]byte(`{
+		"data": {"items": [{"name": "first"}, {"name": "second", "tags": ["a", "b"]}]},
+		"dotted.key": true,
+		"count": 2,
+		"empty": null
+	}`), &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    interface{}
+		wantErr bool
+	}{
+		{path: "$", want: document},
+		{path: "$.count", want: float64(2)},
+		{path: "$.data.items[0].name", want: "first"},
+		{path: "$.data.items[1].tags[1]", want: "b"},
+		{path: "$['dotted.key']", want: true},
+		{path: `$["data"]["items"][1]["name"]`, want: "second"},
+		{path: " $.empty ", want: nil},
+		{path: "$.data.items[2]", wantErr: true},
+		{path: "$.data.missing", wantErr: true},
+		{path: "$.count.value", wantErr: true},
+		{path: "$.data.items.name", wantErr: true},
+		{path: "$.data..items", wantErr: true},
+		{path: "$.data.items[x]", wantErr: true},
+		{path: "$.data.items[0", wantErr: true},
+		{path: "$data", wantErr: true},
+		{path: "data.items", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := evalJSONPath(document, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
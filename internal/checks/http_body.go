@@ -0,0 +1,183 @@
+package checks
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	contentHashesSize = 10000
+	contentHashesTTL  = 24 * time.Hour
+)
+
+type bodyReport struct {
+	wireBytes int64
+	bytes     int64
+	encoding  string
+	sha256    string
+	truncated bool
+	content   []byte
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// readResponseBody reads the response body while counting the bytes received
+// on the wire and after decoding. The decoded content is hashed; up to
+// keepBytes of it are retained for assertions. maxBytes stops the download
+// early when positive.
+func readResponseBody(resp *http.Response, maxBytes, keepBytes int64) (*bodyReport, error) {
+	wire := &countingReader{reader: resp.Body}
+	report := &bodyReport{encoding: strings.ToLower(resp.Header.Get("Content-Encoding"))}
+
+	body := bufio.NewReader(wire)
+	encoding := report.encoding
+	// Responses to HEAD, 204 and 304 keep the Content-Encoding of the full
+	// response but carry no body, so there is nothing to decode.
+	if _, err := body.Peek(1); err == io.EOF {
+		encoding = ""
+	}
+
+	var decoded io.Reader = body
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return report, fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		decoded = gz
+	case "deflate":
+		fl, err := deflateReader(body)
+		if err != nil {
+			return report, fmt.Errorf("deflate: %w", err)
+		}
+		defer fl.Close()
+		decoded = fl
+	}
+
+	limited := decoded
+	if maxBytes > 0 {
+		limited = io.LimitReader(decoded, maxBytes)
+	}
+
+	hasher := sha256.New()
+	kept := &limitedBuffer{limit: keepBytes}
+	n, err := io.Copy(io.MultiWriter(hasher, kept), limited)
+	if err == nil && maxBytes > 0 && n == maxBytes {
+		var probe [1]byte
+		if read, _ := io.ReadFull(decoded, probe[:]); read > 0 {
+			report.truncated = true
+		}
+	}
+
+	report.wireBytes = wire.count
+	report.bytes = n
+	report.sha256 = hex.EncodeToString(hasher.Sum(nil))
+	report.content = kept.data
+
+	return report, err
+}
+
+// deflateReader decodes HTTP deflate, which is the zlib format. Some servers
+// send a raw deflate stream instead, recognised by the missing zlib header.
+func deflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+func (r *bodyReport) payload() map[string]interface{} {
+	return map[string]interface{}{
+		"bytesCompressed":   r.wireBytes,
+		"bytesDecompressed": r.bytes,
+		"contentEncoding":   r.encoding,
+		"sha256":            r.sha256,
+		"truncated":         r.truncated,
+	}
+}
+
+// limitedBuffer is an io.Writer that keeps only the first limit bytes.
+type limitedBuffer struct {
+	limit int64
+	data  []byte
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(len(b.data)); room > 0 {
+		if int64(len(p)) > room {
+			b.data = append(b.data, p[:room]...)
+		} else {
+			b.data = append(b.data, p...)
+		}
+	}
+	return len(p), nil
+}
+
+// contentHashes remembers the last body hash per check so that changes
+// between runs can be reported.
+type contentHashes struct {
+	mu     sync.Mutex
+	hashes map[string]contentHash
+}
+
+type contentHash struct {
+	hash string
+	seen time.Time
+}
+
+func newContentHashes() *contentHashes {
+	return &contentHashes{hashes: make(map[string]contentHash)}
+}
+
+// swap stores hash under key and returns the previously stored one. When the
+// cache is full, hashes of checks that have not run for contentHashesTTL are
+// dropped, and everything if that is not enough.
+func (c *contentHashes) swap(key, hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	previous, ok := c.hashes[key]
+	if !ok && len(c.hashes) >= contentHashesSize {
+		for k, entry := range c.hashes {
+			if now.Sub(entry.seen) > contentHashesTTL {
+				delete(c.hashes, k)
+			}
+		}
+		if len(c.hashes) >= contentHashesSize {
+			c.hashes = make(map[string]contentHash)
+		}
+	}
+	c.hashes[key] = contentHash{hash: hash, seen: now}
+	return previous.hash, ok
+}
+
+func normalizeHash(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return strings.TrimPrefix(value, "sha256:")
+}
@@ -14,10 +14,17 @@ import (
 	"ozzus/agent-aeza/internal/domain"
 )
 
+const (
+	maxAssertionBodyBytes = 10 << 20
+	// defaultMaxBodyBytes bounds the download unless max_body_size is set.
+	defaultMaxBodyBytes = 4 << 20
+)
+
 type HTTPChecker struct {
 	baseMetadata
-	timeout   time.Duration
-	transport *http.Transport
+	timeout       time.Duration
+	transport     *http.Transport
+	contentHashes *contentHashes
 }
 
 func NewHTTPChecker(timeout time.Duration, location, country string) *HTTPChecker {
@@ -34,9 +41,10 @@ func NewHTTPChecker(timeout time.Duration, location, country string) *HTTPChecke
 	}
 
 	return &HTTPChecker{
-		baseMetadata: newBaseMetadata(location, country),
-		timeout:      timeout,
-		transport:    clonedTransport,
+		baseMetadata:  newBaseMetadata(location, country),
+		timeout:       timeout,
+		transport:     clonedTransport,
+		contentHashes: newContentHashes(),
 	}
 }
 
@@ -54,6 +62,26 @@ func (h *HTTPChecker) Check(target string, parameters map[string]interface{}) (*
 		timeout = h.timeout
 	}
 
+	assertions, err := parseHTTPAssertions(parameters)
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), fmt.Errorf("invalid assertions: %w", err), parameters), nil
+	}
+
+	protocol, err := normalizeHTTPProtocol(stringParam(parameters, "protocol", ""))
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), err, parameters), nil
+	}
+
+	tlsConfig, err := buildTLSConfig(parameters)
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), fmt.Errorf("invalid tls parameters: %w", err), parameters), nil
+	}
+
+	dialOpts, err := parseDialOptions(parameters, h.hostForPayload(resolvedURL))
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), err, parameters), nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
@@ -66,33 +94,57 @@ func (h *HTTPChecker) Check(target string, parameters map[string]interface{}) (*
 	defer transport.CloseIdleConnections()
 	dialer := &net.Dialer{Timeout: timeout}
 	var dialIP string
+	proxyDial, err := parseProxy(parameters, dialer, dialOpts)
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), err, parameters), nil
+	}
+	dial := dialOpts.dialContext(dialer)
+	if proxyDial != nil {
+		if protocol == httpProtocolH3 {
+			return h.failureResult(resolvedURL, time.Duration(0), fmt.Errorf("proxy is not supported with h3"), parameters), nil
+		}
+		transport.Proxy = proxyDial.forwardProxy
+		dial = proxyDial.DialContext
+	}
 	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		conn, err := dialer.DialContext(ctx, network, addr)
+		conn, err := dial(ctx, network, addr)
 		if err == nil {
-			if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-				dialIP = tcpAddr.IP.String()
-			} else {
-				dialIP = conn.RemoteAddr().String()
-			}
+			dialIP = remoteIP(conn.RemoteAddr())
 		}
 		return conn, err
 	}
 	transport.TLSHandshakeTimeout = timeout
+	// Decompression is done by readResponseBody so wire and decoded sizes
+	// can both be reported.
+	transport.DisableCompression = true
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
 
-	client := &http.Client{Transport: transport}
-
-	trace := &httptrace.ClientTrace{
-		GotConn: func(info httptrace.GotConnInfo) {
-			if info.Conn == nil {
-				return
-			}
-			if tcpAddr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
-				dialIP = tcpAddr.IP.String()
-			} else {
-				dialIP = info.Conn.RemoteAddr().String()
-			}
-		},
+	roundTripper, err := roundTripperFor(transport, protocol, resolvedURL, dialOpts, func(addr net.Addr) {
+		dialIP = remoteIP(addr)
+	})
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), err, parameters), nil
+	}
+	if closer, ok := roundTripper.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	timings := newHTTPTimings()
+	redirects, err := newRedirectRecorder(parameters, timings)
+	if err != nil {
+		return h.failureResult(resolvedURL, time.Duration(0), err, parameters), nil
 	}
+
+	client := &http.Client{Transport: roundTripper, CheckRedirect: redirects.checkRedirect}
+
+	trace := timings.trace(func(info httptrace.GotConnInfo) {
+		if info.Conn == nil {
+			return
+		}
+		dialIP = remoteIP(info.Conn.RemoteAddr())
+	})
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 
 	if headers, ok := parameters["headers"].(map[string]interface{}); ok {
@@ -100,50 +152,170 @@ func (h *HTTPChecker) Check(target string, parameters map[string]interface{}) (*
 			req.Header.Set(key, fmt.Sprintf("%v", value))
 		}
 	}
+	applyAuth(req, parameters)
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 
 	start := time.Now()
+	timings.begin()
+	redirects.begin(resolvedURL)
 	resp, err := client.Do(req)
 	duration := time.Since(start)
 
 	if err != nil {
-		return h.failureResult(resolvedURL, duration, err, parameters), nil
+		timings.finish()
+		result := h.failureResult(resolvedURL, duration, err, parameters)
+		entry := httpEntry(result)
+		entry["timings"] = timings.payload()
+		if proxyDial != nil {
+			entry["proxy"] = proxyDial.payload()
+		}
+		if hops := redirects.payload(); len(hops) > 0 {
+			entry["redirects"] = hops
+			entry["redirectCount"] = len(hops)
+		}
+		return result, nil
 	}
 	defer resp.Body.Close()
+	redirects.finish(resp)
 
-	// Ensure body is fully read to allow connection reuse
-	_, _ = io.Copy(io.Discard, resp.Body)
+	keepBytes := int64(0)
+	if assertions.needsBody() {
+		keepBytes = maxAssertionBodyBytes
+	}
+	maxBodySize := int64(intParam(parameters, "max_body_size", 0))
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodyBytes
+	}
+	received, err := readResponseBody(resp, maxBodySize, keepBytes)
+	timings.finish()
+	if err != nil {
+		result := h.failureResult(resolvedURL, duration, fmt.Errorf("read body: %w", err), parameters)
+		entry := httpEntry(result)
+		entry["timings"] = timings.payload()
+		if proxyDial != nil {
+			entry["proxy"] = proxyDial.payload()
+		}
+		if hops := redirects.payload(); len(hops) > 1 {
+			entry["redirects"] = hops
+			entry["redirectCount"] = len(hops) - 1
+			entry["finalUrl"] = resp.Request.URL.String()
+		}
+		return result, nil
+	}
 
 	status := domain.StatusSuccess
 	resultText := "OK"
-	if resp.StatusCode >= http.StatusBadRequest {
+	var errText string
+	if !assertions.hasStatus() && resp.StatusCode >= http.StatusBadRequest {
 		status = domain.StatusFailed
 		resultText = "FAILED"
 	}
 
+	var assertionResults []map[string]interface{}
+	if !assertions.empty() {
+		var failure string
+		assertionResults, failure = assertionsPayload(assertions.evaluate(resp, received.content))
+		if failure != "" {
+			status = domain.StatusFailed
+			resultText = "FAILED"
+			errText = failure
+		}
+	}
+
 	ip := dialIP
-	if ip == "" {
+	if proxyDial != nil {
+		// The connection goes to the proxy, so the origin address is unknown.
+		ip = h.hostForPayload(resp.Request.URL.String())
+	} else if ip == "" {
 		ip = h.lookupIP(resolvedURL)
 	}
 
+	entry := map[string]interface{}{
+		"location": h.locationValue(parameters),
+		"country":  h.countryValue(parameters),
+		"time":     formatSeconds(duration),
+		"status":   resp.StatusCode,
+		"ip":       ip,
+		"protocol": resp.Proto,
+		"result":   resultText,
+		"timings":  timings.payload(),
+	}
+	if info := h.ipInfo(ip); info != nil {
+		entry["ipInfo"] = info
+	}
+	if assertionResults != nil {
+		entry["assertions"] = assertionResults
+	}
+	if hops := redirects.payload(); len(hops) > 1 {
+		entry["redirects"] = hops
+		entry["redirectCount"] = len(hops) - 1
+		entry["finalUrl"] = resp.Request.URL.String()
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		entry["redirectLocation"] = location
+	}
+	if headerNames := capturedHeaderNames(parameters); len(headerNames) > 0 {
+		entry["headers"] = captureHeaders(resp.Header, headerNames)
+	}
+	if proxyDial != nil {
+		entry["proxy"] = proxyDial.payload()
+	}
+
+	bodyPayload := received.payload()
+	if expected := normalizeHash(stringParam(parameters, "expected_hash", "")); expected != "" {
+		matched := expected == received.sha256
+		bodyPayload["hashMatch"] = matched
+		if !matched && status == domain.StatusSuccess {
+			status, resultText = domain.StatusFailed, "FAILED"
+			errText = "content hash does not match expected_hash"
+		}
+	}
+	if boolParam(parameters, "detect_change", false) {
+		key := stringParam(parameters, "change_key", method+" "+resolvedURL)
+		previous, seen := h.contentHashes.swap(key, received.sha256)
+		changed := seen && previous != received.sha256
+		bodyPayload["changed"] = changed
+		if seen {
+			bodyPayload["previousSha256"] = previous
+		}
+		if changed && status == domain.StatusSuccess {
+			status, resultText = domain.StatusFailed, "FAILED"
+			errText = "content changed since last run"
+		}
+	}
+	entry["body"] = bodyPayload
+
+	if boolParam(parameters, "security_audit", false) {
+		entry["securityHeaders"] = auditSecurityHeaders(resp)
+	}
+
 	payload := map[string]interface{}{
-		"http": []map[string]interface{}{
-			{
-				"location": h.locationValue(parameters),
-				"country":  h.countryValue(parameters),
-				"time":     formatSeconds(duration),
-				"status":   resp.StatusCode,
-				"ip":       ip,
-				"result":   resultText,
-			},
-		},
+		"http": []map[string]interface{}{entry},
 	}
 
 	return &domain.CheckResult{
 		Status:  status,
+		Error:   errText,
 		Payload: payload,
 	}, nil
 }
 
+// httpEntry returns the location entry of an http payload so callers can
+// extend it with optional sections.
+func httpEntry(result *domain.CheckResult) map[string]interface{} {
+	payload, ok := result.Payload.(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	entries, ok := payload["http"].([]map[string]interface{})
+	if !ok || len(entries) == 0 {
+		return map[string]interface{}{}
+	}
+	return entries[0]
+}
+
 func (h *HTTPChecker) lookupIP(target string) string {
 	parsed, err := url.Parse(target)
 	if err != nil {
@@ -0,0 +1,105 @@
+package checks
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	httpProtocolAuto   = ""
+	httpProtocolHTTP11 = "http1.1"
+	httpProtocolH2     = "h2"
+	httpProtocolH3     = "h3"
+)
+
+func normalizeHTTPProtocol(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "auto":
+		return httpProtocolAuto, nil
+	case "http1.1", "http/1.1", "http1", "h1", "1.1":
+		return httpProtocolHTTP11, nil
+	case "h2", "http2", "http/2":
+		return httpProtocolH2, nil
+	case "h3", "http3", "http/3", "quic":
+		return httpProtocolH3, nil
+	default:
+		return "", fmt.Errorf("unsupported protocol: %s", raw)
+	}
+}
+
+// roundTripperFor configures transport to speak only the requested protocol.
+// For HTTP/3 a separate QUIC transport is returned; the caller must close it.
+// connected receives the remote address of every QUIC connection, which the
+// dial hooks of transport never see.
+func roundTripperFor(transport *http.Transport, protocol, targetURL string, dialOpts *dialOptions, connected func(net.Addr)) (http.RoundTripper, error) {
+	switch protocol {
+	case httpProtocolHTTP11:
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		transport.Protocols = protocols
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSClientConfig = withNextProtos(transport.TLSClientConfig, "http/1.1")
+		return transport, nil
+	case httpProtocolH2:
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = protocols
+		transport.ForceAttemptHTTP2 = true
+		return transport, nil
+	case httpProtocolH3:
+		parsed, err := url.Parse(targetURL)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.Scheme != "https" {
+			return nil, fmt.Errorf("h3 requires an https url")
+		}
+
+		tlsConfig := transport.TLSClientConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+
+		return &http3.Transport{
+			TLSClientConfig:    tlsConfig,
+			DisableCompression: transport.DisableCompression,
+			QUICConfig: &quic.Config{
+				HandshakeIdleTimeout: transport.TLSHandshakeTimeout,
+			},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+				resolved, err := dialOpts.resolve(ctx, addr)
+				if err != nil {
+					return nil, err
+				}
+				conn, err := quic.DialAddrEarly(ctx, resolved, tlsCfg, cfg)
+				if err == nil && connected != nil {
+					connected(conn.RemoteAddr())
+				}
+				return conn, err
+			},
+		}, nil
+	default:
+		return transport, nil
+	}
+}
+
+func withNextProtos(config *tls.Config, protos ...string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.NextProtos = protos
+	return config
+}
@@ -0,0 +1,114 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRedirects = 10
+	// maxURLVisits allows a chain to return to a URL once, as login bounces
+	// that set a cookie do (A -> B -> A). A third visit is a loop.
+	maxURLVisits = 2
+)
+
+var errRedirectLoop = errors.New("redirect loop detected")
+
+type redirectRecorder struct {
+	mu sync.Mutex
+
+	follow       bool
+	maxRedirects int
+	timings      *httpTimings
+
+	hopStart time.Time
+	visited  map[string]int
+	hops     []map[string]interface{}
+}
+
+// newRedirectRecorder reads follow_redirects and max_redirects. A limit of
+// 0 does not follow redirects, like follow_redirects: false.
+func newRedirectRecorder(params map[string]interface{}, timings *httpTimings) (*redirectRecorder, error) {
+	maxRedirects := intParam(params, "max_redirects", defaultMaxRedirects)
+	if maxRedirects < 0 {
+		return nil, fmt.Errorf("max_redirects must not be negative")
+	}
+
+	return &redirectRecorder{
+		follow:       boolParam(params, "follow_redirects", true) && maxRedirects > 0,
+		maxRedirects: maxRedirects,
+		timings:      timings,
+		visited:      make(map[string]int),
+	}, nil
+}
+
+func (r *redirectRecorder) begin(target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hopStart = time.Now()
+	r.visited[target]++
+}
+
+// checkRedirect is installed as http.Client.CheckRedirect. It records the
+// response that triggered the redirect and enforces the redirect policy.
+func (r *redirectRecorder) checkRedirect(req *http.Request, via []*http.Request) error {
+	if !r.follow {
+		// The redirect response becomes the final one and is recorded by finish.
+		return http.ErrUseLastResponse
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Response != nil {
+		r.hops = append(r.hops, r.hopEntry(req.Response))
+	}
+	r.hopStart = time.Now()
+
+	if len(via) > r.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", r.maxRedirects)
+	}
+
+	next := req.URL.String()
+	if r.visited[next] >= maxURLVisits {
+		return fmt.Errorf("%w: %s", errRedirectLoop, next)
+	}
+	r.visited[next]++
+
+	if r.timings != nil {
+		r.timings.restart()
+	}
+
+	return nil
+}
+
+// finish records the final response of the chain.
+func (r *redirectRecorder) finish(resp *http.Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hops = append(r.hops, r.hopEntry(resp))
+}
+
+func (r *redirectRecorder) hopEntry(resp *http.Response) map[string]interface{} {
+	entry := map[string]interface{}{
+		"url":    resp.Request.URL.String(),
+		"status": resp.StatusCode,
+		"time":   durationMillis(time.Since(r.hopStart)),
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		entry["location"] = location
+	}
+	return entry
+}
+
+func (r *redirectRecorder) payload() []map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hops := make([]map[string]interface{}, len(r.hops))
+	copy(hops, r.hops)
+	return hops
+}
@@ -0,0 +1,81 @@
+package checks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedirectRecorderLoops(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		hit := hits[r.URL.Path]
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/login":
+			// A login bounce: the first visit goes to /auth, which sends the
+			// client back once the session is set up.
+			if hit == 1 {
+				http.Redirect(w, r, "/auth", http.StatusFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/auth":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/ping":
+			http.Redirect(w, r, "/pong", http.StatusFound)
+		case "/pong":
+			http.Redirect(w, r, "/ping", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		wantLoop bool
+		wantHops int
+	}{
+		{path: "/login", wantHops: 3},
+		{path: "/ping", wantLoop: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder, err := newRedirectRecorder(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{CheckRedirect: recorder.checkRedirect, Timeout: 5 * time.Second}
+
+			target := server.URL + tt.path
+			recorder.begin(target)
+			resp, err := client.Get(target)
+			if tt.wantLoop {
+				if !errors.Is(err, errRedirectLoop) {
+					t.Fatalf("expected redirect loop, got %v", err)
+				}
+				if !strings.HasSuffix(err.Error(), server.URL+"/ping") {
+					t.Fatalf("expected the loop to be reported at /ping, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			recorder.finish(resp)
+
+			if hops := recorder.payload(); len(hops) != tt.wantHops {
+				t.Fatalf("got %d hops, want %d: %v", len(hops), tt.wantHops, hops)
+			}
+		})
+	}
+}
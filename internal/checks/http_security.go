@@ -0,0 +1,281 @@
+package checks
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	auditPass = "pass"
+	auditWarn = "warn"
+	auditFail = "fail"
+	auditSkip = "skip"
+
+	// hstsMinMaxAge is the six month minimum commonly required for HSTS preload.
+	hstsMinMaxAge = 15552000
+)
+
+type headerAudit struct {
+	name    string
+	value   string
+	status  string
+	message string
+}
+
+// captureHeaders returns the requested response headers. The special name
+// "*" selects every header.
+func captureHeaders(header http.Header, names []string) map[string]string {
+	captured := make(map[string]string)
+	for _, name := range names {
+		if name == "*" {
+			for key, values := range header {
+				captured[key] = strings.Join(values, ", ")
+			}
+			continue
+		}
+		if values := header.Values(name); len(values) > 0 {
+			captured[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+		}
+	}
+	return captured
+}
+
+// capturedHeaderNames reads capture_headers, which is either a list of header
+// names or true to capture everything.
+func capturedHeaderNames(params map[string]interface{}) []string {
+	names := stringListParam(params, "capture_headers")
+	if len(names) == 1 {
+		if enabled, err := strconv.ParseBool(names[0]); err == nil {
+			if enabled {
+				return []string{"*"}
+			}
+			return nil
+		}
+	}
+	return names
+}
+
+func auditSecurityHeaders(resp *http.Response) map[string]interface{} {
+	https := resp.Request != nil && resp.Request.URL.Scheme == "https"
+	csp := resp.Header.Get("Content-Security-Policy")
+
+	audits := []headerAudit{
+		auditHSTS(resp.Header.Get("Strict-Transport-Security"), https),
+		auditCSP(csp),
+		auditFrameOptions(resp.Header.Get("X-Frame-Options"), csp),
+		auditContentTypeOptions(resp.Header.Get("X-Content-Type-Options")),
+		auditReferrerPolicy(resp.Header.Get("Referrer-Policy")),
+	}
+
+	cookies := auditCookies(resp.Cookies(), https)
+	cookieStatus := auditPass
+	for _, cookie := range cookies {
+		if cookie["status"] != auditPass {
+			cookieStatus = auditWarn
+		}
+	}
+	if len(cookies) == 0 {
+		cookieStatus = auditSkip
+	}
+
+	checks := make([]map[string]interface{}, 0, len(audits))
+	score, total := 0, 0
+	for _, audit := range audits {
+		checks = append(checks, map[string]interface{}{
+			"header":  audit.name,
+			"value":   audit.value,
+			"status":  audit.status,
+			"message": audit.message,
+		})
+		score, total = addAuditScore(score, total, audit.status)
+	}
+	score, total = addAuditScore(score, total, cookieStatus)
+
+	percent := 0
+	if total > 0 {
+		percent = score * 100 / total
+	}
+
+	return map[string]interface{}{
+		"grade":   securityGrade(percent),
+		"score":   percent,
+		"checks":  checks,
+		"cookies": cookies,
+	}
+}
+
+func addAuditScore(score, total int, status string) (int, int) {
+	switch status {
+	case auditPass:
+		return score + 2, total + 2
+	case auditWarn:
+		return score + 1, total + 2
+	case auditFail:
+		return score, total + 2
+	default:
+		return score, total
+	}
+}
+
+func securityGrade(percent int) string {
+	switch {
+	case percent >= 90:
+		return "A"
+	case percent >= 75:
+		return "B"
+	case percent >= 60:
+		return "C"
+	case percent >= 40:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+func auditHSTS(value string, https bool) headerAudit {
+	audit := headerAudit{name: "Strict-Transport-Security", value: value}
+	if !https {
+		audit.status, audit.message = auditSkip, "not applicable over plain http"
+		return audit
+	}
+	if value == "" {
+		audit.status, audit.message = auditFail, "header is missing"
+		return audit
+	}
+
+	maxAge := -1
+	for _, directive := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(key, "max-age") {
+			if parsed, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil {
+				maxAge = parsed
+			}
+		}
+	}
+
+	switch {
+	case maxAge < 0:
+		audit.status, audit.message = auditFail, "max-age directive is missing or invalid"
+	case maxAge < hstsMinMaxAge:
+		audit.status, audit.message = auditWarn, "max-age is shorter than 180 days"
+	default:
+		audit.status = auditPass
+	}
+	return audit
+}
+
+func auditCSP(value string) headerAudit {
+	audit := headerAudit{name: "Content-Security-Policy", value: value}
+	lower := strings.ToLower(value)
+
+	switch {
+	case value == "":
+		audit.status, audit.message = auditFail, "header is missing"
+	case strings.Contains(lower, "'unsafe-inline'") || strings.Contains(lower, "'unsafe-eval'"):
+		audit.status, audit.message = auditWarn, "policy allows unsafe-inline or unsafe-eval"
+	default:
+		audit.status = auditPass
+	}
+	return audit
+}
+
+func auditFrameOptions(value, csp string) headerAudit {
+	audit := headerAudit{name: "X-Frame-Options", value: value}
+
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "DENY", "SAMEORIGIN":
+		audit.status = auditPass
+	case "":
+		if strings.Contains(strings.ToLower(csp), "frame-ancestors") {
+			audit.status, audit.message = auditPass, "covered by CSP frame-ancestors"
+		} else {
+			audit.status, audit.message = auditFail, "header is missing"
+		}
+	default:
+		audit.status, audit.message = auditWarn, "unrecognised value"
+	}
+	return audit
+}
+
+func auditContentTypeOptions(value string) headerAudit {
+	audit := headerAudit{name: "X-Content-Type-Options", value: value}
+
+	switch {
+	case value == "":
+		audit.status, audit.message = auditFail, "header is missing"
+	case strings.EqualFold(strings.TrimSpace(value), "nosniff"):
+		audit.status = auditPass
+	default:
+		audit.status, audit.message = auditFail, "value must be nosniff"
+	}
+	return audit
+}
+
+func auditReferrerPolicy(value string) headerAudit {
+	audit := headerAudit{name: "Referrer-Policy", value: value}
+	if value == "" {
+		audit.status, audit.message = auditFail, "header is missing"
+		return audit
+	}
+
+	// Browsers apply the last listed policy they support.
+	policies := strings.Split(value, ",")
+	policy := strings.ToLower(strings.TrimSpace(policies[len(policies)-1]))
+
+	switch policy {
+	case "no-referrer", "same-origin", "strict-origin", "strict-origin-when-cross-origin":
+		audit.status = auditPass
+	case "origin", "origin-when-cross-origin", "no-referrer-when-downgrade":
+		audit.status, audit.message = auditWarn, "policy may leak referrer information"
+	case "unsafe-url":
+		audit.status, audit.message = auditFail, "unsafe-url leaks full urls"
+	default:
+		audit.status, audit.message = auditWarn, "unrecognised policy"
+	}
+	return audit
+}
+
+func auditCookies(cookies []*http.Cookie, https bool) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(cookies))
+	for _, cookie := range cookies {
+		missing := make([]string, 0, 3)
+		if https && !cookie.Secure {
+			missing = append(missing, "Secure")
+		}
+		if !cookie.HttpOnly {
+			missing = append(missing, "HttpOnly")
+		}
+		if cookie.SameSite == 0 || cookie.SameSite == http.SameSiteDefaultMode {
+			missing = append(missing, "SameSite")
+		}
+
+		status := auditPass
+		if len(missing) > 0 {
+			status = auditWarn
+		}
+
+		result = append(result, map[string]interface{}{
+			"name":     cookie.Name,
+			"secure":   cookie.Secure,
+			"httpOnly": cookie.HttpOnly,
+			"sameSite": sameSiteName(cookie.SameSite),
+			"missing":  missing,
+			"status":   status,
+		})
+	}
+	return result
+}
+
+func sameSiteName(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,155 @@
+package checks
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+type httpTimings struct {
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	transferDone time.Time
+	connReused   bool
+}
+
+func newHTTPTimings() *httpTimings {
+	return &httpTimings{}
+}
+
+func (t *httpTimings) begin() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start = time.Now()
+}
+
+// restart clears the recorded phases before the next request of a redirect
+// chain so the breakdown always describes the last hop.
+func (t *httpTimings) restart() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset()
+}
+
+// nextRequest is called when the transport starts a request. A request
+// already written means this is a follow-up, such as a redirect, and its
+// phases must not be mixed with the previous one.
+func (t *httpTimings) nextRequest() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.wroteRequest.IsZero() && t.firstByte.IsZero() {
+		return
+	}
+	t.reset()
+}
+
+func (t *httpTimings) reset() {
+	t.start = time.Now()
+	t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+	t.connectStart, t.connectDone = time.Time{}, time.Time{}
+	t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+	t.wroteRequest, t.firstByte = time.Time{}, time.Time{}
+	t.connReused = false
+}
+
+func (t *httpTimings) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transferDone = time.Now()
+}
+
+func (t *httpTimings) trace(gotConn func(httptrace.GotConnInfo)) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.nextRequest()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mark(&t.dnsStart, true)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mark(&t.dnsDone, false)
+		},
+		ConnectStart: func(_, _ string) {
+			t.mark(&t.connectStart, true)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.mark(&t.connectDone, true)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mark(&t.tlsStart, true)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mark(&t.tlsDone, false)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.connReused = info.Reused
+			t.mu.Unlock()
+
+			if gotConn != nil {
+				gotConn(info)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mark(&t.wroteRequest, false)
+		},
+		GotFirstResponseByte: func() {
+			t.mark(&t.firstByte, true)
+		},
+	}
+}
+
+// mark stores the current time in field. When onlyFirst is set an already
+// recorded value is kept, so parallel dial attempts report the earliest start.
+func (t *httpTimings) mark(field *time.Time, onlyFirst bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if onlyFirst && !field.IsZero() {
+		return
+	}
+	*field = time.Now()
+}
+
+func (t *httpTimings) payload() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := time.Duration(0)
+	if !t.start.IsZero() {
+		end := t.transferDone
+		if end.IsZero() {
+			end = time.Now()
+		}
+		total = end.Sub(t.start)
+	}
+
+	return map[string]interface{}{
+		"dnsLookup":       phaseMillis(t.dnsStart, t.dnsDone),
+		"tcpConnect":      phaseMillis(t.connectStart, t.connectDone),
+		"tlsHandshake":    phaseMillis(t.tlsStart, t.tlsDone),
+		"serverResponse":  phaseMillis(t.wroteRequest, t.firstByte),
+		"firstByte":       phaseMillis(t.start, t.firstByte),
+		"contentTransfer": phaseMillis(t.firstByte, t.transferDone),
+		"total":           durationMillis(total),
+		"connReused":      t.connReused,
+	}
+}
+
+func phaseMillis(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return durationMillis(end.Sub(start))
+}
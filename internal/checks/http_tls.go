@@ -0,0 +1,80 @@
+package checks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// buildTLSConfig assembles the client TLS settings of an HTTP check: custom
+// CA bundle, client certificate for mTLS and certificate verification switch.
+// Returns nil when no TLS parameters are set.
+func buildTLSConfig(params map[string]interface{}) (*tls.Config, error) {
+	caBundle := stringParam(params, "ca_bundle", "")
+	clientCert := stringParam(params, "client_cert", "")
+	clientKey := stringParam(params, "client_key", "")
+	insecure := boolParam(params, "insecure_skip_verify", false)
+
+	if caBundle == "" && clientCert == "" && clientKey == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caBundle != "" {
+		data, err := inlinePEM(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("ca_bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ca_bundle: no certificates found")
+		}
+		config.RootCAs = pool
+	}
+
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, fmt.Errorf("client_cert and client_key must be set together")
+		}
+		certPEM, err := inlinePEM(clientCert)
+		if err != nil {
+			return nil, fmt.Errorf("client_cert: %w", err)
+		}
+		keyPEM, err := inlinePEM(clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client_key: %w", err)
+		}
+		certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// inlinePEM accepts PEM data only. Paths are refused: reading files named by
+// a task would expose anything on the agent host to whoever sends tasks.
+func inlinePEM(value string) ([]byte, error) {
+	if !strings.Contains(value, "-----BEGIN") {
+		return nil, errors.New("inline PEM data expected")
+	}
+	return []byte(value), nil
+}
+
+func applyAuth(req *http.Request, params map[string]interface{}) {
+	if basic := mapParam(params, "basic_auth"); basic != nil {
+		req.SetBasicAuth(stringParam(basic, "username", ""), stringParam(basic, "password", ""))
+	}
+	if token := stringParam(params, "bearer_token", ""); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
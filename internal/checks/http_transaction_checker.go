@@ -0,0 +1,378 @@
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"ozzus/agent-aeza/internal/domain"
+)
+
+type HTTPTransactionChecker struct {
+	baseMetadata
+	timeout   time.Duration
+	transport *http.Transport
+}
+
+type extraction struct {
+	name       string
+	source     string
+	expression string
+	re         *regexp.Regexp
+}
+
+func NewHTTPTransactionChecker(timeout time.Duration, location, country string) *HTTPTransactionChecker {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		transport = &http.Transport{}
+	}
+
+	return &HTTPTransactionChecker{
+		baseMetadata: newBaseMetadata(location, country),
+		timeout:      timeout,
+		transport:    transport,
+	}
+}
+
+func (h *HTTPTransactionChecker) Check(target string, parameters map[string]interface{}) (*domain.CheckResult, error) {
+	steps, ok := parameters["steps"].([]interface{})
+	if !ok || len(steps) == 0 {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: "steps parameter is required"}, nil
+	}
+
+	timeout := durationParam(parameters, "timeout", h.timeout)
+	if timeout <= 0 {
+		timeout = h.timeout
+	}
+
+	baseURL, err := h.baseURL(target)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("invalid url: %v", err)}, nil
+	}
+
+	tlsConfig, err := buildTLSConfig(parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("invalid tls parameters: %v", err)}, nil
+	}
+
+	dialOpts, err := parseDialOptions(parameters, baseURL.Hostname())
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("cookie jar: %v", err)}, nil
+	}
+
+	transport := h.transport.Clone()
+	defer transport.CloseIdleConnections()
+	transport.DialContext = dialOpts.dialContext(&net.Dialer{Timeout: timeout})
+	transport.TLSHandshakeTimeout = timeout
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client := &http.Client{Transport: transport, Jar: jar}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	variables := make(map[string]string)
+	for key, value := range mapParam(parameters, "variables") {
+		variables[key] = fmt.Sprintf("%v", value)
+	}
+
+	status := domain.StatusSuccess
+	resultText := "OK"
+	var errText string
+	results := make([]map[string]interface{}, 0, len(steps))
+
+	start := time.Now()
+	for i, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			status, resultText = domain.StatusFailed, "FAILED"
+			errText = fmt.Sprintf("step %d: invalid definition", i+1)
+			break
+		}
+
+		stepResult, stepErr := h.runStep(ctx, client, baseURL, step, variables)
+		stepResult["step"] = i + 1
+		results = append(results, stepResult)
+
+		if stepErr != nil {
+			status, resultText = domain.StatusFailed, "FAILED"
+			errText = fmt.Sprintf("step %d (%s): %v", i+1, stringParam(step, "name", ""), stepErr)
+			break
+		}
+	}
+	duration := time.Since(start)
+
+	payload := map[string]interface{}{
+		"http_transaction": []map[string]interface{}{
+			{
+				"location": h.locationValue(parameters),
+				"country":  h.countryValue(parameters),
+				"time":     formatSeconds(duration),
+				"steps":    results,
+				"result":   resultText,
+			},
+		},
+	}
+
+	return &domain.CheckResult{
+		Status:  status,
+		Error:   errText,
+		Payload: payload,
+	}, nil
+}
+
+func (h *HTTPTransactionChecker) runStep(ctx context.Context, client *http.Client, baseURL *url.URL, step map[string]interface{}, variables map[string]string) (map[string]interface{}, error) {
+	replacer := variableReplacer(variables, nil)
+
+	result := map[string]interface{}{
+		"name": stringParam(step, "name", ""),
+	}
+
+	urlReplacer := variableReplacer(variables, escapeURLValue)
+	stepURL, err := baseURL.Parse(urlReplacer.Replace(stringParam(step, "url", "")))
+	if err != nil {
+		return result, fmt.Errorf("invalid url: %w", err)
+	}
+	result["url"] = stepURL.String()
+
+	assertions, err := parseHTTPAssertions(step)
+	if err != nil {
+		return result, fmt.Errorf("invalid assertions: %w", err)
+	}
+
+	extractions, err := parseExtractions(step)
+	if err != nil {
+		return result, err
+	}
+
+	if stepTimeout := durationParam(step, "timeout", 0); stepTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, stepTimeout)
+		defer cancel()
+	}
+
+	method := strings.ToUpper(stringParam(step, "method", "GET"))
+	body := replacer.Replace(stringParam(step, "body", ""))
+
+	req, err := http.NewRequestWithContext(ctx, method, stepURL.String(), strings.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	for key, value := range mapParam(step, "headers") {
+		req.Header.Set(key, replacer.Replace(fmt.Sprintf("%v", value)))
+	}
+	applyAuth(req, substituteAuth(step, replacer))
+
+	timings := newHTTPTimings()
+	var ip string
+	trace := timings.trace(func(info httptrace.GotConnInfo) {
+		if info.Conn != nil {
+			ip = remoteIP(info.Conn.RemoteAddr())
+		}
+	})
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	timings.begin()
+	resp, err := client.Do(req)
+	if ip != "" {
+		result["ip"] = ip
+		if info := h.ipInfo(ip); info != nil {
+			result["ipInfo"] = info
+		}
+	}
+	if err != nil {
+		timings.finish()
+		result["time"] = formatSeconds(time.Since(start))
+		result["timings"] = timings.payload()
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, maxAssertionBodyBytes))
+	timings.finish()
+	result["time"] = formatSeconds(time.Since(start))
+	result["timings"] = timings.payload()
+	result["status"] = resp.StatusCode
+	if err != nil {
+		return result, fmt.Errorf("read body: %w", err)
+	}
+
+	var failure string
+	if !assertions.empty() {
+		var outcomes []map[string]interface{}
+		outcomes, failure = assertionsPayload(assertions.evaluate(resp, responseBody))
+		result["assertions"] = outcomes
+	}
+	if !assertions.hasStatus() && resp.StatusCode >= http.StatusBadRequest && failure == "" {
+		failure = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+
+	if failure != "" {
+		return result, errors.New(failure)
+	}
+
+	extracted := make([]string, 0, len(extractions))
+	for _, ex := range extractions {
+		value, err := ex.apply(resp, responseBody)
+		if err != nil {
+			result["extracted"] = extracted
+			return result, fmt.Errorf("extract %s: %w", ex.name, err)
+		}
+		variables[ex.name] = value
+		extracted = append(extracted, ex.name)
+	}
+	if len(extracted) > 0 {
+		result["extracted"] = extracted
+	}
+
+	return result, nil
+}
+
+func (h *HTTPTransactionChecker) baseURL(target string) (*url.URL, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, fmt.Errorf("empty target")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	return url.Parse(target)
+}
+
+// parseExtractions reads the extract list of a step. Each entry names a
+// variable and takes it from a json_path, regex (first group) or header.
+func parseExtractions(step map[string]interface{}) ([]extraction, error) {
+	raw, ok := step["extract"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	result := make([]extraction, 0, len(raw))
+	for _, item := range raw {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid extract entry: %v", item)
+		}
+		ex := extraction{
+			name:       stringParam(entry, "name", ""),
+			source:     strings.ToLower(stringParam(entry, "type", "json_path")),
+			expression: stringParam(entry, "expression", ""),
+		}
+		if ex.name == "" || ex.expression == "" {
+			return nil, fmt.Errorf("extract entry requires name and expression")
+		}
+		switch ex.source {
+		case "json_path", "header":
+		case "regex":
+			re, err := regexp.Compile(ex.expression)
+			if err != nil {
+				return nil, fmt.Errorf("extract %s: invalid regex: %w", ex.name, err)
+			}
+			ex.re = re
+		default:
+			return nil, fmt.Errorf("extract %s: unsupported type %s", ex.name, ex.source)
+		}
+		result = append(result, ex)
+	}
+	return result, nil
+}
+
+func (e extraction) apply(resp *http.Response, body []byte) (string, error) {
+	switch e.source {
+	case "header":
+		value := resp.Header.Get(e.expression)
+		if value == "" {
+			return "", fmt.Errorf("header %s not found", e.expression)
+		}
+		return value, nil
+	case "regex":
+		matches := e.re.FindSubmatch(body)
+		if matches == nil {
+			return "", fmt.Errorf("no match")
+		}
+		if len(matches) > 1 {
+			return string(matches[1]), nil
+		}
+		return string(matches[0]), nil
+	default:
+		var document interface{}
+		if err := json.Unmarshal(body, &document); err != nil {
+			return "", fmt.Errorf("invalid json: %w", err)
+		}
+		value, err := evalJSONPath(document, e.expression)
+		if err != nil {
+			return "", err
+		}
+		if text, ok := value.(string); ok {
+			return text, nil
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
+
+// substituteAuth returns the authentication parameters of a step with
+// variables expanded, so tokens extracted earlier can be used.
+func substituteAuth(step map[string]interface{}, replacer *strings.Replacer) map[string]interface{} {
+	auth := map[string]interface{}{
+		"bearer_token": replacer.Replace(stringParam(step, "bearer_token", "")),
+	}
+	if basic := mapParam(step, "basic_auth"); basic != nil {
+		auth["basic_auth"] = map[string]interface{}{
+			"username": replacer.Replace(stringParam(basic, "username", "")),
+			"password": replacer.Replace(stringParam(basic, "password", "")),
+		}
+	}
+	return auth
+}
+
+// variableReplacer substitutes {{name}} with the value passed through escape,
+// if set, and {{name|raw}} with the value as is.
+func variableReplacer(variables map[string]string, escape func(string) string) *strings.Replacer {
+	pairs := make([]string, 0, len(variables)*4)
+	for key, value := range variables {
+		escaped := value
+		if escape != nil {
+			escaped = escape(value)
+		}
+		pairs = append(pairs, "{{"+key+"|raw}}", value, "{{"+key+"}}", escaped)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// escapeURLValue percent-encodes a value so that it stays one path segment
+// or query value; spaces become %20, which is valid in both.
+func escapeURLValue(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func (h *HTTPTransactionChecker) Type() domain.TaskType {
+	return domain.TaskTypeHTTPTransaction
+}
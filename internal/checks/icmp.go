@@ -0,0 +1,249 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// icmpSocket is an ICMP endpoint for one address family. It prefers an
+// unprivileged datagram socket (net.ipv4.ping_group_range) and falls back to
+// a raw socket, which needs root or CAP_NET_RAW.
+type icmpSocket struct {
+	conn net.PacketConn
+	ipv6 bool
+	raw  bool
+	// id is the echo identifier. Datagram sockets have it rewritten by the
+	// kernel, which also filters replies, so it is only checked for raw ones.
+	id int
+}
+
+func listenICMP(ipv6 bool) (*icmpSocket, error) {
+	conn, dgramErr := listenDatagramICMP(ipv6)
+	if dgramErr == nil {
+		return &icmpSocket{conn: conn, ipv6: ipv6, id: rand.Intn(0xffff) + 1}, nil
+	}
+
+	socket, rawErr := listenRawICMP(ipv6)
+	if rawErr != nil {
+		return nil, fmt.Errorf("icmp socket: %v; %v", dgramErr, rawErr)
+	}
+	return socket, nil
+}
+
+// listenRawICMP opens a raw ICMP socket. Unlike datagram sockets it also
+// receives ICMP errors such as time exceeded, which traceroute relies on.
+func listenRawICMP(ipv6 bool) (*icmpSocket, error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("raw socket: %w", err)
+	}
+	return &icmpSocket{conn: conn, ipv6: ipv6, raw: true, id: rand.Intn(0xffff) + 1}, nil
+}
+
+// listenRecvErrICMP opens a datagram ICMP socket with IP_RECVERR set, so
+// the ICMP errors that a raw socket would receive are queued on it instead.
+func listenRecvErrICMP(ipv6 bool) (*icmpSocket, error) {
+	conn, err := listenDatagramICMP(ipv6)
+	if err != nil {
+		return nil, err
+	}
+	if err := enableRecvErr(conn, ipv6); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &icmpSocket{conn: conn, ipv6: ipv6, id: rand.Intn(0xffff) + 1}, nil
+}
+
+// queuedError is an ICMP error that the kernel queued on a socket with
+// IP_RECVERR set, about a packet sent from that socket.
+type queuedError struct {
+	// from is the router or host that sent the error.
+	from     net.IP
+	icmpType int
+	icmpCode int
+	// port is the destination port of the failed packet on UDP sockets.
+	port int
+	// payload is the start of the failed packet, from the ICMP header on
+	// ICMP sockets and from the payload on UDP sockets.
+	payload []byte
+}
+
+// rawSocketError explains a permission error of a check that needs raw
+// sockets, which the agent only gets with CAP_NET_RAW.
+func rawSocketError(check string, err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("%s needs CAP_NET_RAW: %w", check, err)
+	}
+	return err
+}
+
+func (s *icmpSocket) Close() error {
+	return s.conn.Close()
+}
+
+// icmpOptions are IP-level settings for outgoing probes. Zero values keep
+// the system defaults.
+type icmpOptions struct {
+	ttl          int
+	tos          int
+	dontFragment bool
+}
+
+func (s *icmpSocket) apply(opts icmpOptions) error {
+	if opts.ttl > 0 {
+		if err := setTTL(s.conn, s.ipv6, opts.ttl); err != nil {
+			return err
+		}
+	}
+	if opts.tos > 0 {
+		if s.ipv6 {
+			if err := ipv6.NewPacketConn(s.conn).SetTrafficClass(opts.tos); err != nil {
+				return fmt.Errorf("set traffic class: %w", err)
+			}
+		} else if err := ipv4.NewPacketConn(s.conn).SetTOS(opts.tos); err != nil {
+			return fmt.Errorf("set tos: %w", err)
+		}
+	}
+
+	if opts.dontFragment {
+		if err := setDontFragment(s.conn, s.ipv6); err != nil {
+			return fmt.Errorf("set don't fragment: %w", err)
+		}
+	}
+	return nil
+}
+
+// setTTL sets the unicast TTL or hop limit of packets sent on conn.
+func setTTL(conn net.PacketConn, ipv6Conn bool, ttl int) error {
+	if ipv6Conn {
+		if err := ipv6.NewPacketConn(conn).SetHopLimit(ttl); err != nil {
+			return fmt.Errorf("set hop limit: %w", err)
+		}
+		return nil
+	}
+	if err := ipv4.NewPacketConn(conn).SetTTL(ttl); err != nil {
+		return fmt.Errorf("set ttl: %w", err)
+	}
+	return nil
+}
+
+func (s *icmpSocket) protocol() int {
+	if s.ipv6 {
+		return protocolICMPv6
+	}
+	return protocolICMP
+}
+
+func (s *icmpSocket) addr(ip net.IP) net.Addr {
+	if s.raw {
+		return &net.IPAddr{IP: ip}
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+func (s *icmpSocket) sendEcho(ip net.IP, seq int, data []byte) error {
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	if s.ipv6 {
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+
+	message := icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{ID: s.id, Seq: seq, Data: data},
+	}
+	// The kernel fills in the ICMPv6 checksum, so no pseudo header is needed.
+	packet, err := message.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.WriteTo(packet, s.addr(ip))
+	return err
+}
+
+// read returns the next ICMP message and the address it came from, skipping
+// packets that do not parse.
+func (s *icmpSocket) read(buf []byte) (*icmp.Message, net.IP, error) {
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		message, err := icmp.ParseMessage(s.protocol(), buf[:n])
+		if err != nil {
+			continue
+		}
+
+		var ip net.IP
+		switch a := from.(type) {
+		case *net.IPAddr:
+			ip = a.IP
+		case *net.UDPAddr:
+			ip = a.IP
+		}
+		return message, ip, nil
+	}
+}
+
+// echoReply reports whether message is a reply to one of our echo requests.
+func (s *icmpSocket) echoReply(message *icmp.Message) (*icmp.Echo, bool) {
+	if message.Type != ipv4.ICMPTypeEchoReply && message.Type != ipv6.ICMPTypeEchoReply {
+		return nil, false
+	}
+	echo, ok := message.Body.(*icmp.Echo)
+	if !ok || (s.raw && echo.ID != s.id) {
+		return nil, false
+	}
+	return echo, true
+}
+
+// resolveTarget returns the address to probe. IPv4 is preferred unless
+// ipVersion asks for IPv6.
+func resolveTarget(ctx context.Context, host string, ipVersion int) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ipVersion == 4 && ip.To4() == nil || ipVersion == 6 && ip.To4() != nil {
+			return nil, fmt.Errorf("%s does not match ip_version %d", host, ipVersion)
+		}
+		return ip, nil
+	}
+
+	network := "ip"
+	switch ipVersion {
+	case 4:
+		network = "ip4"
+	case 6:
+		network = "ip6"
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	return ips[0], nil
+}
@@ -0,0 +1,212 @@
+package checks
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+func listenDatagramICMP(ipv6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, protocolICMP
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if ipv6 {
+		family, proto = syscall.AF_INET6, protocolICMPv6
+		sa = &syscall.SockaddrInet6{}
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "icmp")
+	defer file.Close()
+	return net.FilePacketConn(file)
+}
+
+// setDontFragment sets DF on IPv4 packets and disables fragmentation for
+// IPv6, so oversized probes fail instead of being fragmented.
+func setDontFragment(conn net.PacketConn, ipv6 bool) error {
+	return setMTUDiscover(conn, ipv6, syscall.IP_PMTUDISC_DO, syscall.IPV6_PMTUDISC_DO)
+}
+
+// setProbeMTU disables fragmentation like setDontFragment but ignores the
+// path MTU the kernel has cached, so packets up to the interface MTU still
+// leave and the router that cannot forward them reports it.
+func setProbeMTU(conn net.PacketConn, ipv6 bool) error {
+	return setMTUDiscover(conn, ipv6, syscall.IP_PMTUDISC_PROBE, syscall.IPV6_PMTUDISC_PROBE)
+}
+
+func setMTUDiscover(conn net.PacketConn, ipv6 bool, mode4, mode6 int) error {
+	raw, err := syscallConn(conn)
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, mode6)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, mode4)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+// enableRecvErr sets IP_RECVERR, or IPV6_RECVERR, so the kernel queues the
+// ICMP errors of packets sent on conn for readRecvErr.
+func enableRecvErr(conn net.PacketConn, ipv6 bool) error {
+	raw, err := syscallConn(conn)
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+
+	// sockExtendedErrLen is the size of struct sock_extended_err, which is
+	// followed by the address of the host that sent the error.
+	sockExtendedErrLen = 16
+)
+
+// readRecvErr reads the next datagram or queued ICMP error from conn, which
+// must have IP_RECVERR set. For datagrams queued is nil and n bytes of buf
+// are filled.
+func readRecvErr(conn net.PacketConn, buf []byte) (n int, from net.IP, queued *queuedError, err error) {
+	raw, err := syscallConn(conn)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	oob := make([]byte, 512)
+	var readErr error
+	err = raw.Read(func(fd uintptr) bool {
+		readErr = nil
+		// A queued error also sets the socket error, which the next recvfrom
+		// returns once. Those are skipped, the queue has the details.
+		for pending := 0; ; pending++ {
+			qn, oobn, _, dst, err := syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err == nil {
+				if queued = parseQueuedError(buf[:qn], oob[:oobn], dst); queued != nil {
+					return true
+				}
+				continue
+			}
+
+			var sa syscall.Sockaddr
+			n, sa, readErr = syscall.Recvfrom(int(fd), buf, syscall.MSG_DONTWAIT)
+			switch {
+			case readErr == nil:
+				from = sockaddrIP(sa)
+				return true
+			case readErr == syscall.EAGAIN:
+				return false
+			case readErr == syscall.EINTR:
+			case pending >= 16:
+				readErr = os.NewSyscallError("recvfrom", readErr)
+				return true
+			}
+		}
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if readErr != nil {
+		return 0, nil, nil, readErr
+	}
+	return n, from, queued, nil
+}
+
+// parseQueuedError decodes the IP_RECVERR control message of an error queue
+// entry. It returns nil for errors that do not come from an ICMP message.
+func parseQueuedError(payload, oob []byte, dst syscall.Sockaddr) *queuedError {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	for _, message := range messages {
+		isRecvErr := message.Header.Level == syscall.IPPROTO_IP && message.Header.Type == syscall.IP_RECVERR ||
+			message.Header.Level == syscall.IPPROTO_IPV6 && message.Header.Type == syscall.IPV6_RECVERR
+		data := message.Data
+		if !isRecvErr || len(data) < sockExtendedErrLen {
+			continue
+		}
+		if origin := data[4]; origin != soEEOriginICMP && origin != soEEOriginICMP6 {
+			return nil
+		}
+
+		queued := &queuedError{
+			from:     offenderIP(data[sockExtendedErrLen:]),
+			icmpType: int(data[5]),
+			icmpCode: int(data[6]),
+			payload:  append([]byte(nil), payload...),
+		}
+		switch sa := dst.(type) {
+		case *syscall.SockaddrInet4:
+			queued.port = sa.Port
+		case *syscall.SockaddrInet6:
+			queued.port = sa.Port
+		}
+		return queued
+	}
+	return nil
+}
+
+// offenderIP returns the address of the raw struct sockaddr that follows a
+// struct sock_extended_err.
+func offenderIP(sa []byte) net.IP {
+	if len(sa) < 2 {
+		return nil
+	}
+	switch family := binary.NativeEndian.Uint16(sa); {
+	case family == syscall.AF_INET && len(sa) >= 8:
+		return net.IP(append([]byte(nil), sa[4:8]...))
+	case family == syscall.AF_INET6 && len(sa) >= 24:
+		return net.IP(append([]byte(nil), sa[8:24]...))
+	}
+	return nil
+}
+
+func sockaddrIP(sa syscall.Sockaddr) net.IP {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(append([]byte(nil), sa.Addr[:]...))
+	case *syscall.SockaddrInet6:
+		return net.IP(append([]byte(nil), sa.Addr[:]...))
+	}
+	return nil
+}
+
+func syscallConn(conn net.PacketConn) (syscall.RawConn, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("unsupported connection")
+	}
+	return sc.SyscallConn()
+}
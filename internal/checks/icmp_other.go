@@ -0,0 +1,28 @@
+//go:build !linux
+
+package checks
+
+import (
+	"errors"
+	"net"
+)
+
+func listenDatagramICMP(ipv6 bool) (net.PacketConn, error) {
+	return nil, errors.New("datagram icmp sockets are only supported on linux")
+}
+
+func setDontFragment(conn net.PacketConn, ipv6 bool) error {
+	return errors.New("not supported on this platform")
+}
+
+func setProbeMTU(conn net.PacketConn, ipv6 bool) error {
+	return errors.New("not supported on this platform")
+}
+
+func enableRecvErr(conn net.PacketConn, ipv6 bool) error {
+	return errors.New("not supported on this platform")
+}
+
+func readRecvErr(conn net.PacketConn, buf []byte) (int, net.IP, *queuedError, error) {
+	return 0, nil, nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,301 @@
+package checks
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// IPDatabase annotates addresses with their autonomous system and location
+// from local MaxMind (mmdb) or CSV files, so agents never call external
+// services for it. Several files are merged, e.g. an ASN and a City database.
+type IPDatabase struct {
+	paths []string
+
+	mu      sync.RWMutex
+	sources []ipSource
+}
+
+type ipSource interface {
+	lookup(ip netip.Addr) (ipInfo, bool)
+	Close() error
+}
+
+type ipInfo struct {
+	asn     uint
+	asName  string
+	country string
+	city    string
+}
+
+// OpenIPDatabase loads the given mmdb and CSV files.
+func OpenIPDatabase(paths ...string) (*IPDatabase, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no ip database files")
+	}
+
+	db := &IPDatabase{paths: paths}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload reads all files again and swaps them in only once every one of them
+// has loaded, so a broken update keeps the previous data.
+func (db *IPDatabase) Reload() error {
+	sources := make([]ipSource, 0, len(db.paths))
+	for _, path := range db.paths {
+		source, err := openIPSource(path)
+		if err != nil {
+			for _, loaded := range sources {
+				loaded.Close()
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		sources = append(sources, source)
+	}
+
+	db.mu.Lock()
+	previous := db.sources
+	db.sources = sources
+	db.mu.Unlock()
+
+	for _, source := range previous {
+		source.Close()
+	}
+	return nil
+}
+
+func (db *IPDatabase) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, source := range db.sources {
+		source.Close()
+	}
+	db.sources = nil
+	return nil
+}
+
+// lookup merges what every file knows about ip. Earlier files win.
+func (db *IPDatabase) lookup(ip string) (ipInfo, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if db == nil || err != nil {
+		return ipInfo{}, false
+	}
+	addr = addr.Unmap()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var info ipInfo
+	found := false
+	for _, source := range db.sources {
+		part, ok := source.lookup(addr)
+		if !ok {
+			continue
+		}
+		found = true
+		if info.asn == 0 {
+			info.asn = part.asn
+		}
+		if info.asName == "" {
+			info.asName = part.asName
+		}
+		if info.country == "" {
+			info.country = part.country
+		}
+		if info.city == "" {
+			info.city = part.city
+		}
+	}
+	return info, found
+}
+
+func (i ipInfo) payload() map[string]interface{} {
+	return map[string]interface{}{
+		"asn":     i.asn,
+		"asName":  i.asName,
+		"country": i.country,
+		"city":    i.city,
+	}
+}
+
+func openIPSource(path string) (ipSource, error) {
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbSource{reader: reader}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readCSVSource(file)
+}
+
+// mmdbRecord covers the fields of the GeoLite2/GeoIP2 ASN, Country and City
+// databases.
+type mmdbRecord struct {
+	ASN     uint   `maxminddb:"autonomous_system_number"`
+	ASOrg   string `maxminddb:"autonomous_system_organization"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type mmdbSource struct {
+	reader *maxminddb.Reader
+}
+
+func (s mmdbSource) lookup(ip netip.Addr) (ipInfo, bool) {
+	var record mmdbRecord
+	_, ok, err := s.reader.LookupNetwork(net.IP(ip.AsSlice()), &record)
+	if err != nil || !ok {
+		return ipInfo{}, false
+	}
+
+	country := record.Country.ISOCode
+	if country == "" {
+		country = record.RegisteredCountry.ISOCode
+	}
+	return ipInfo{
+		asn:     record.ASN,
+		asName:  record.ASOrg,
+		country: country,
+		city:    record.City.Names["en"],
+	}, true
+}
+
+func (s mmdbSource) Close() error {
+	return s.reader.Close()
+}
+
+// csvColumns maps header names, including those of the GeoLite2 CSV files,
+// to fields. Files without a header use network,asn,as_name,country,city.
+var csvColumns = map[string]string{
+	"network":                        "network",
+	"cidr":                           "network",
+	"prefix":                         "network",
+	"asn":                            "asn",
+	"autonomous_system_number":       "asn",
+	"as_name":                        "asName",
+	"as_org":                         "asName",
+	"autonomous_system_organization": "asName",
+	"country":                        "country",
+	"country_code":                   "country",
+	"country_iso_code":               "country",
+	"city":                           "city",
+	"city_name":                      "city",
+}
+
+// csvSource holds CIDR prefixes and answers with the longest match.
+type csvSource struct {
+	prefixes map[netip.Prefix]ipInfo
+	// lengths are the prefix lengths in use, longest first.
+	lengths []int
+}
+
+func readCSVSource(r io.Reader) (*csvSource, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	source := &csvSource{prefixes: make(map[netip.Prefix]ipInfo)}
+	columns := map[string]int{"network": 0, "asn": 1, "asName": 2, "country": 3, "city": 4}
+	lengths := make(map[int]bool)
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		prefix, err := netip.ParsePrefix(field("network"))
+		if err != nil {
+			if line == 1 {
+				columns = csvHeader(record)
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prefix = prefix.Masked()
+
+		asn, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(field("asn")), "AS"), 10, 32)
+		source.prefixes[prefix] = ipInfo{
+			asn:     uint(asn),
+			asName:  field("asName"),
+			country: field("country"),
+			city:    field("city"),
+		}
+		lengths[prefix.Bits()] = true
+	}
+
+	for bits := range lengths {
+		source.lengths = append(source.lengths, bits)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(source.lengths)))
+	return source, nil
+}
+
+func csvHeader(record []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range record {
+		if field, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	return columns
+}
+
+func (s *csvSource) lookup(ip netip.Addr) (ipInfo, bool) {
+	for _, bits := range s.lengths {
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if info, ok := s.prefixes[prefix]; ok {
+			return info, true
+		}
+	}
+	return ipInfo{}, false
+}
+
+func (s *csvSource) Close() error {
+	return nil
+}
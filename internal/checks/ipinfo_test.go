@@ -0,0 +1,73 @@
+package checks
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		lookups map[string]*ipInfo
+		wantErr bool
+	}{
+		{
+			name: "without header",
+			data: `# network,asn,as_name,country,city
+192.0.2.0/24,AS64500,Example Net,NL,Amsterdam
+192.0.2.128/25, 64501, "Example, Sub",DE,Berlin
+2001:db8::/32,64502,V6 Net,US
+`,
+			lookups: map[string]*ipInfo{
+				"192.0.2.1":    {asn: 64500, asName: "Example Net", country: "NL", city: "Amsterdam"},
+				"192.0.2.200":  {asn: 64501, asName: "Example, Sub", country: "DE", city: "Berlin"},
+				"2001:db8::1":  {asn: 64502, asName: "V6 Net", country: "US"},
+				"198.51.100.1": nil,
+			},
+		},
+		{
+			name: "geolite2 header",
+			data: `network,autonomous_system_number,autonomous_system_organization
+10.1.2.3/8,64510,Private
+`,
+			lookups: map[string]*ipInfo{
+				"10.200.0.1": {asn: 64510, asName: "Private"},
+				"11.0.0.1":   nil,
+			},
+		},
+		{
+			name:    "invalid prefix after header",
+			data:    "network,asn\n192.0.2.0/24,1\nnot-a-prefix,2\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := readCSVSource(strings.NewReader(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for addr, want := range tt.lookups {
+				got, ok := source.lookup(netip.MustParseAddr(addr))
+				if want == nil {
+					if ok {
+						t.Errorf("%s: unexpected match %+v", addr, got)
+					}
+					continue
+				}
+				if !ok || got != *want {
+					t.Errorf("%s: got %+v (found %v), want %+v", addr, got, ok, *want)
+				}
+			}
+		})
+	}
+}
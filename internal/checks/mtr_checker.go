@@ -0,0 +1,253 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"ozzus/agent-aeza/internal/domain"
+)
+
+const (
+	mtrDefaultDuration = 10 * time.Second
+	mtrDefaultInterval = time.Second
+	mtrMinInterval     = 100 * time.Millisecond
+	// mtrMaxDuration bounds how long one check keeps the agent busy.
+	mtrMaxDuration = 5 * time.Minute
+	// mtrMaxProbes keeps probe keys within the UDP port range above 33434.
+	mtrMaxProbes = 30000
+)
+
+// MTRChecker repeats traceroute rounds over a period of time and aggregates
+// loss and latency per hop, like mtr --report.
+type MTRChecker struct {
+	baseMetadata
+	maxHops int
+	timeout time.Duration
+	names   *reverseNames
+}
+
+func NewMTRChecker(maxHops int, timeout time.Duration, location, country string) *MTRChecker {
+	if maxHops <= 0 {
+		maxHops = 30
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	return &MTRChecker{
+		baseMetadata: newBaseMetadata(location, country),
+		maxHops:      maxHops,
+		timeout:      timeout,
+		names:        newReverseNames(),
+	}
+}
+
+func (m *MTRChecker) Check(target string, parameters map[string]interface{}) (*domain.CheckResult, error) {
+	host, err := normalizeHostname(target)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	protocol, port, err := traceProtocolParams(parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	// Multipath needs several flows per round, which mtr does not aggregate.
+	mode := lowerStringParam(parameters, "mode", "classic")
+	if mode != "classic" && mode != "paris" {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("unsupported mode: %s", mode)}, nil
+	}
+
+	maxHops, err := traceMaxHopsParam(parameters, m.maxHops)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	timeout := durationParam(parameters, "timeout", m.timeout)
+	if timeout <= 0 {
+		timeout = m.timeout
+	}
+
+	duration := durationParam(parameters, "duration", mtrDefaultDuration)
+	interval := durationParam(parameters, "interval", mtrDefaultInterval)
+	if interval < mtrMinInterval {
+		interval = mtrMinInterval
+	}
+	if duration > mtrMaxDuration || interval > mtrMaxDuration {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("duration and interval must be at most %s", mtrMaxDuration)}, nil
+	}
+	rounds := int(duration / interval)
+	if rounds < 1 {
+		rounds = 1
+	}
+	if rounds*maxHops > mtrMaxProbes {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("too many probes: %d rounds of %d hops", rounds, maxHops)}, nil
+	}
+
+	ipVersion := intParam(parameters, "ip_version", 0)
+	if ipVersion != 0 && ipVersion != 4 && ipVersion != 6 {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: "ip_version must be 4 or 6"}, nil
+	}
+
+	resolveCtx, cancelResolve := context.WithTimeout(context.Background(), timeout)
+	dst, err := resolveTarget(resolveCtx, host, ipVersion)
+	cancelResolve()
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	tr, err := newTracer(dst, traceConfig{protocol: protocol, port: port, mode: mode, maxHops: maxHops, probes: 1, timeout: timeout})
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+	defer tr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(rounds)*interval+timeout)
+	defer cancel()
+
+	start := time.Now()
+	var sendErr error
+	lastRound := 0
+	for round := 0; round < rounds && ctx.Err() == nil; round++ {
+		if round > 0 {
+			select {
+			case <-time.After(time.Until(start.Add(time.Duration(round) * interval))):
+			case <-ctx.Done():
+			}
+		}
+		lastRound = tr.mark()
+		if err := tr.sendRound(ctx, 0); err != nil && sendErr == nil {
+			sendErr = err
+		}
+	}
+	tr.wait(ctx, lastRound, timeout)
+	elapsed := time.Since(start)
+
+	end, found := tr.pathEnd()
+	hops := traceHops(tr.results(), end)
+
+	details := addressDetails{ipdb: m.ipdb}
+	if reverseDNS, dnsTimeout := reverseDNSParams(parameters); reverseDNS {
+		details.names = m.names.lookup(hopAddresses(hops), dnsTimeout)
+	}
+
+	hopResults := make([]map[string]interface{}, 0, len(hops))
+	answered := false
+	for _, hop := range hops {
+		hopResults = append(hopResults, mtrHopPayload(hop, details))
+		for _, probe := range hop.probes {
+			answered = answered || probe.replied
+		}
+	}
+
+	status := domain.StatusSuccess
+	resultText := "OK"
+	var errText string
+	switch {
+	case !answered && sendErr != nil:
+		errText = sendErr.Error()
+	case !found:
+		errText = fmt.Sprintf("destination not reached within %d hops", maxHops)
+	case !reachedDestination(hops[len(hops)-1]):
+		errText = unreachableError(hops[len(hops)-1])
+	}
+	if errText != "" {
+		status = domain.StatusFailed
+		resultText = "FAILED"
+	}
+
+	entry := map[string]interface{}{
+		"location": m.locationValue(parameters),
+		"country":  m.countryValue(parameters),
+		"ip":       dst.String(),
+		"protocol": protocol,
+		"mode":     mode,
+		"rounds":   rounds,
+		"time":     formatSeconds(elapsed),
+		"hops":     hopResults,
+		"result":   resultText,
+	}
+	if port > 0 {
+		entry["port"] = port
+	}
+
+	payload := map[string]interface{}{
+		"mtr": []map[string]interface{}{entry},
+	}
+
+	return &domain.CheckResult{
+		Status:  status,
+		Error:   errText,
+		Payload: payload,
+	}, nil
+}
+
+// mtrHopPayload aggregates all probes of one TTL. ip is the address that
+// answered most often; addressChanges counts how often consecutive replies
+// came from a different router.
+func mtrHopPayload(hop traceHop, details addressDetails) map[string]interface{} {
+	replies := make([]pingReply, 0, len(hop.probes))
+	counts := make(map[string]int)
+	var order []string
+	var previous string
+	var last time.Duration
+	changes := 0
+
+	for i, probe := range hop.probes {
+		if !probe.replied {
+			continue
+		}
+		replies = append(replies, pingReply{seq: i + 1, rtt: probe.rtt})
+		last = probe.rtt
+
+		ip := probe.from.String()
+		if counts[ip] == 0 {
+			order = append(order, ip)
+		}
+		counts[ip]++
+		if previous != "" && ip != previous {
+			changes++
+		}
+		previous = ip
+	}
+
+	stats := newPingStats(len(hop.probes), replies)
+
+	ip := "*"
+	addresses := make([]map[string]interface{}, 0, len(order))
+	for _, address := range order {
+		if ip == "*" || counts[address] > counts[ip] {
+			ip = address
+		}
+		entry := map[string]interface{}{
+			"ip":    address,
+			"count": counts[address],
+		}
+		details.add(entry, address)
+		addresses = append(addresses, entry)
+	}
+
+	result := map[string]interface{}{
+		"hop":            hop.ttl,
+		"ip":             ip,
+		"addresses":      addresses,
+		"addressChanges": changes,
+		"sent":           len(hop.probes),
+		"received":       stats.received,
+		"loss":           math.Round(stats.loss*100) / 100,
+		"last":           durationMillis(last),
+		"best":           durationMillis(stats.min),
+		"avg":            durationMillis(stats.avg),
+		"worst":          durationMillis(stats.max),
+		"stddev":         durationMillis(stats.stddev),
+	}
+	details.add(result, ip)
+	return result
+}
+
+func (m *MTRChecker) Type() domain.TaskType {
+	return domain.TaskTypeMTR
+}
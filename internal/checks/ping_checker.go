@@ -2,27 +2,41 @@ package checks
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
-	"strconv"
-	"strings"
+	"net"
+	"sync"
 	"time"
 
 	"ozzus/agent-aeza/internal/domain"
 )
 
-var (
-	pingSummaryRegexp = regexp.MustCompile(`(?m)(\d+) packets transmitted, (\d+) received, ([0-9.]+)% packet loss`)
-	pingIPRegexp      = regexp.MustCompile(`(?m)^PING [^\(]*\(([0-9a-fA-F:\.]+)\)`)
-	pingRttRegexp     = regexp.MustCompile(`(?m)rtt [^=]*= ([0-9.]+)/([0-9.]+)/([0-9.]+)/`)
+const (
+	pingMaxCount       = 100
+	pingInterval       = time.Second
+	pingMinInterval    = 10 * time.Millisecond
+	pingPayloadSize    = 56
+	pingMaxPayloadSize = 65500
 )
 
 type PingChecker struct {
 	baseMetadata
 	timeout time.Duration
 	count   int
+	names   *reverseNames
+}
+
+type pingConfig struct {
+	count    int
+	size     int
+	interval time.Duration
+	options  icmpOptions
+}
+
+type pingReply struct {
+	seq        int
+	rtt        time.Duration
+	duplicate  bool
+	outOfOrder bool
 }
 
 func NewPingChecker(timeout time.Duration, count int, location, country string) *PingChecker {
@@ -37,6 +51,7 @@ func NewPingChecker(timeout time.Duration, count int, location, country string)
 		baseMetadata: newBaseMetadata(location, country),
 		timeout:      timeout,
 		count:        count,
+		names:        newReverseNames(),
 	}
 }
 
@@ -46,9 +61,9 @@ func (p *PingChecker) Check(target string, parameters map[string]interface{}) (*
 		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
 	}
 
-	count := intParam(parameters, "count", p.count)
-	if count <= 0 {
-		count = p.count
+	config, err := p.parseConfig(parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
 	}
 
 	timeout := durationParam(parameters, "timeout", p.timeout)
@@ -56,102 +71,246 @@ func (p *PingChecker) Check(target string, parameters map[string]interface{}) (*
 		timeout = p.timeout
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Second)
+	ipVersion := intParam(parameters, "ip_version", 0)
+	if ipVersion != 0 && ipVersion != 4 && ipVersion != 6 {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: "ip_version must be 4 or 6"}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	args := []string{"-n", "-c", strconv.Itoa(count), "-w", fmt.Sprintf("%d", int(timeout.Seconds())), host}
-	cmd := exec.CommandContext(ctx, "ping", args...)
+	ip, err := resolveTarget(ctx, host, ipVersion)
+	if err != nil {
+		return p.result(parameters, host, 0, nil, err, nil), nil
+	}
 
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+	socket, err := listenICMP(ip.To4() == nil)
+	if err != nil {
+		return p.result(parameters, ip.String(), 0, nil, err, nil), nil
+	}
+	defer socket.Close()
 
-	if ctx.Err() != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
-		err = ctx.Err()
+	if err := socket.apply(config.options); err != nil {
+		return p.result(parameters, ip.String(), 0, nil, err, nil), nil
 	}
 
-	transmitted, received, loss := p.parseSummary(outputStr)
-	minRTT, avgRTT, maxRTT := p.parseRTT(outputStr)
-	ip := p.parseIP(outputStr, host)
-	lossText := fmt.Sprintf("%.0f%%", loss)
+	// The PTR lookup runs while pinging.
+	var hostname chan string
+	if reverseDNS, dnsTimeout := reverseDNSParams(parameters); reverseDNS {
+		hostname = make(chan string, 1)
+		go func() {
+			hostname <- p.names.lookup([]string{ip.String()}, dnsTimeout)[ip.String()]
+		}()
+	}
 
-	payload := map[string]interface{}{
-		"ping": []map[string]interface{}{
-			{
-				"location": p.locationValue(parameters),
-				"country":  p.countryValue(parameters),
-				"ip":       ip,
-				"packets": map[string]interface{}{
-					"transmitted": transmitted,
-					"received":    received,
-					"loss":        lossText,
-				},
-				"roundTrip": map[string]interface{}{
-					"min": minRTT,
-					"avg": avgRTT,
-					"max": maxRTT,
-				},
-			},
-		},
+	sent, replies, err := p.ping(ctx, socket, ip, config)
+	var extra map[string]interface{}
+	if hostname != nil {
+		extra = map[string]interface{}{"hostname": <-hostname}
 	}
+	return p.result(parameters, ip.String(), sent, replies, err, extra), nil
+}
 
-	status := domain.StatusSuccess
-	var errText string
+// parseConfig reads count, size, interval, ttl, tos/dscp and dont_fragment.
+func (p *PingChecker) parseConfig(parameters map[string]interface{}) (pingConfig, error) {
+	config := pingConfig{
+		count:    intParam(parameters, "count", p.count),
+		size:     intParam(parameters, "size", pingPayloadSize),
+		interval: durationParam(parameters, "interval", pingInterval),
+		options: icmpOptions{
+			ttl:          intParam(parameters, "ttl", 0),
+			tos:          intParam(parameters, "tos", 0),
+			dontFragment: boolParam(parameters, "dont_fragment", false),
+		},
+	}
 
-	if err != nil || received == 0 {
-		status = domain.StatusFailed
-		if err != nil {
-			errText = err.Error()
-		} else {
-			errText = "no packets received"
+	if config.count <= 0 {
+		config.count = p.count
+	}
+	if config.count > pingMaxCount {
+		return config, fmt.Errorf("count must be at most %d", pingMaxCount)
+	}
+	if config.size < 0 || config.size > pingMaxPayloadSize {
+		return config, fmt.Errorf("size must be between 0 and %d", pingMaxPayloadSize)
+	}
+	if config.interval <= 0 {
+		config.interval = pingInterval
+	} else if config.interval < pingMinInterval {
+		config.interval = pingMinInterval
+	}
+	if config.options.ttl < 0 || config.options.ttl > 255 {
+		return config, fmt.Errorf("ttl must be between 1 and 255")
+	}
+	if _, ok := parameters["dscp"]; ok {
+		dscp := intParam(parameters, "dscp", 0)
+		if dscp < 0 || dscp > 63 {
+			return config, fmt.Errorf("dscp must be between 0 and 63")
 		}
+		// DSCP occupies the upper six bits of the TOS / traffic class byte.
+		config.options.tos = dscp << 2
+	}
+	if config.options.tos < 0 || config.options.tos > 255 {
+		return config, fmt.Errorf("tos must be between 0 and 255")
 	}
 
-	return &domain.CheckResult{
-		Status:  status,
-		Error:   errText,
-		Payload: payload,
-	}, nil
+	return config, nil
 }
 
-func (p *PingChecker) parseSummary(output string) (int, int, float64) {
-	matches := pingSummaryRegexp.FindStringSubmatch(output)
-	if len(matches) != 4 {
-		return p.count, 0, 100
+// ping sends echo requests one interval apart and collects replies until all
+// of them arrived or the context deadline passed.
+func (p *PingChecker) ping(ctx context.Context, socket *icmpSocket, ip net.IP, config pingConfig) (int, []pingReply, error) {
+	count := config.count
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = socket.conn.SetReadDeadline(deadline)
 	}
 
-	transmitted, _ := strconv.Atoi(matches[1])
-	received, _ := strconv.Atoi(matches[2])
-	loss, _ := strconv.ParseFloat(matches[3], 64)
+	var mu sync.Mutex
+	sentAt := make(map[int]time.Time, count)
+	seen := make(map[int]bool, count)
+	replies := make([]pingReply, 0, count)
+	highestSeq := 0
+	done := make(chan struct{})
 
-	return transmitted, received, loss
-}
+	go func() {
+		defer close(done)
+		buf := make([]byte, config.size+1500)
+		for {
+			message, _, err := socket.read(buf)
+			if err != nil {
+				return
+			}
+			received := time.Now()
+			echo, ok := socket.echoReply(message)
+			if !ok {
+				continue
+			}
+
+			mu.Lock()
+			start, ok := sentAt[echo.Seq]
+			if ok {
+				reply := pingReply{
+					seq:        echo.Seq,
+					rtt:        received.Sub(start),
+					duplicate:  seen[echo.Seq],
+					outOfOrder: echo.Seq < highestSeq,
+				}
+				replies = append(replies, reply)
+				seen[echo.Seq] = true
+				if echo.Seq > highestSeq {
+					highestSeq = echo.Seq
+				}
+			}
+			finished := len(seen) == count
+			mu.Unlock()
+			if finished {
+				return
+			}
+		}
+	}()
+
+	data := make([]byte, config.size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	sent := 0
+	var sendErr error
+send:
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-time.After(config.interval):
+			case <-ctx.Done():
+				break send
+			case <-done:
+				break send
+			}
+		}
+
+		mu.Lock()
+		sentAt[seq] = time.Now()
+		mu.Unlock()
+		if err := socket.sendEcho(ip, seq, data); err != nil {
+			mu.Lock()
+			delete(sentAt, seq)
+			mu.Unlock()
+			if sendErr == nil {
+				sendErr = err
+			}
+		}
+		sent++
+	}
 
-func (p *PingChecker) parseRTT(output string) (string, string, string) {
-	matches := pingRttRegexp.FindStringSubmatch(output)
-	if len(matches) != 4 {
-		return "0.0 ms", "0.0 ms", "0.0 ms"
+	mu.Lock()
+	inFlight := len(sentAt) - len(seen)
+	mu.Unlock()
+	if inFlight == 0 {
+		// Nothing is in flight, e.g. because every send failed.
+		_ = socket.conn.SetReadDeadline(time.Now())
 	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
 
-	return fmt.Sprintf("%s ms", matches[1]), fmt.Sprintf("%s ms", matches[2]), fmt.Sprintf("%s ms", matches[3])
+	if len(replies) == 0 && sendErr != nil {
+		return sent, replies, sendErr
+	}
+	return sent, replies, nil
 }
 
-func (p *PingChecker) parseIP(output, fallback string) string {
-	matches := pingIPRegexp.FindStringSubmatch(output)
-	if len(matches) == 2 {
-		return matches[1]
+// result builds the check result; extra fields are added to the ping entry.
+func (p *PingChecker) result(parameters map[string]interface{}, ip string, sent int, replies []pingReply, err error, extra map[string]interface{}) *domain.CheckResult {
+	stats := newPingStats(sent, replies)
+	received := stats.received
+
+	entry := map[string]interface{}{
+		"location": p.locationValue(parameters),
+		"country":  p.countryValue(parameters),
+		"ip":       ip,
+		"packets": map[string]interface{}{
+			"transmitted": sent,
+			"received":    received,
+			"loss":        fmt.Sprintf("%.0f%%", stats.loss),
+			"duplicates":  stats.duplicates,
+			"outOfOrder":  stats.outOfOrder,
+		},
+		"roundTrip": map[string]interface{}{
+			"min": formatMilliseconds(stats.min),
+			"avg": formatMilliseconds(stats.avg),
+			"max": formatMilliseconds(stats.max),
+		},
+		"statistics": stats.payload(),
+		"replies":    pingSequence(sent, replies),
+	}
+	if info := p.ipInfo(ip); info != nil {
+		entry["ipInfo"] = info
+	}
+	for key, value := range extra {
+		entry[key] = value
 	}
 
-	// Try to extract from "bytes from" lines
-	bytesFromRegexp := regexp.MustCompile(`bytes from ([^\s]+)(?: \(([0-9a-fA-F:\.]+)\))?`)
-	matches = bytesFromRegexp.FindStringSubmatch(output)
-	if len(matches) >= 3 && matches[2] != "" {
-		return matches[2]
+	payload := map[string]interface{}{
+		"ping": []map[string]interface{}{entry},
 	}
-	if len(matches) >= 2 {
-		return strings.Trim(matches[1], "<>")
+
+	status := domain.StatusSuccess
+	var errText string
+
+	if err != nil || received == 0 {
+		status = domain.StatusFailed
+		if err != nil {
+			errText = err.Error()
+		} else {
+			errText = "no packets received"
+		}
 	}
 
-	return fallback
+	return &domain.CheckResult{
+		Status:  status,
+		Error:   errText,
+		Payload: payload,
+	}
 }
 
 func (p *PingChecker) Type() domain.TaskType {
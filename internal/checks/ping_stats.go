@@ -0,0 +1,129 @@
+package checks
+
+import (
+	"math"
+	"time"
+)
+
+type pingStats struct {
+	sent       int
+	received   int
+	duplicates int
+	outOfOrder int
+	loss       float64
+	min        time.Duration
+	avg        time.Duration
+	max        time.Duration
+	stddev     time.Duration
+	jitter     time.Duration
+}
+
+// newPingStats summarises replies in arrival order. Duplicates are counted
+// but left out of the RTT figures.
+func newPingStats(sent int, replies []pingReply) pingStats {
+	stats := pingStats{sent: sent, loss: 100}
+
+	var sum, sumSquares, jitter float64
+	var previous time.Duration
+	for _, reply := range replies {
+		if reply.duplicate {
+			stats.duplicates++
+			continue
+		}
+		if reply.outOfOrder {
+			stats.outOfOrder++
+		}
+
+		if stats.received == 0 || reply.rtt < stats.min {
+			stats.min = reply.rtt
+		}
+		if reply.rtt > stats.max {
+			stats.max = reply.rtt
+		}
+		if stats.received > 0 {
+			// RFC 3550 interarrival jitter: J += (|D| - J) / 16.
+			delta := math.Abs(float64(reply.rtt - previous))
+			jitter += (delta - jitter) / 16
+		}
+		previous = reply.rtt
+
+		value := float64(reply.rtt)
+		sum += value
+		sumSquares += value * value
+		stats.received++
+	}
+
+	if sent > 0 {
+		stats.loss = float64(sent-stats.received) / float64(sent) * 100
+	}
+	if stats.received > 0 {
+		mean := sum / float64(stats.received)
+		stats.avg = time.Duration(mean)
+		stats.stddev = time.Duration(math.Sqrt(math.Max(sumSquares/float64(stats.received)-mean*mean, 0)))
+		stats.jitter = time.Duration(jitter)
+	}
+
+	return stats
+}
+
+func (s pingStats) payload() map[string]interface{} {
+	result := map[string]interface{}{
+		"min":    durationMillis(s.min),
+		"avg":    durationMillis(s.avg),
+		"max":    durationMillis(s.max),
+		"stddev": durationMillis(s.stddev),
+		"jitter": durationMillis(s.jitter),
+		"loss":   math.Round(s.loss*100) / 100,
+	}
+	if s.received > 0 {
+		result["mos"] = s.mos()
+	}
+	return result
+}
+
+// mos estimates a VoIP Mean Opinion Score (1–4.5) from latency, jitter and
+// loss with the simplified ITU-T G.107 E-model.
+func (s pingStats) mos() float64 {
+	effectiveLatency := durationMillis(s.avg) + 2*durationMillis(s.jitter) + 10
+
+	r := 93.2 - effectiveLatency/40
+	if effectiveLatency >= 160 {
+		r = 93.2 - (effectiveLatency-120)/10
+	}
+	r -= s.loss * 2.5
+	r = math.Max(0, math.Min(100, r))
+
+	score := 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+	return math.Round(score*100) / 100
+}
+
+// pingSequence lists every sent sequence number with its first RTT, or
+// received=false when no reply came back.
+func pingSequence(sent int, replies []pingReply) []map[string]interface{} {
+	firstRTT := make(map[int]time.Duration, len(replies))
+	duplicates := make(map[int]int)
+	for _, reply := range replies {
+		if reply.duplicate {
+			duplicates[reply.seq]++
+			continue
+		}
+		firstRTT[reply.seq] = reply.rtt
+	}
+
+	result := make([]map[string]interface{}, 0, sent)
+	for seq := 1; seq <= sent; seq++ {
+		entry := map[string]interface{}{
+			"seq":      seq,
+			"received": false,
+		}
+		if rtt, ok := firstRTT[seq]; ok {
+			entry["received"] = true
+			entry["time"] = durationMillis(rtt)
+		}
+		if n := duplicates[seq]; n > 0 {
+			entry["duplicates"] = n
+		}
+		result = append(result, entry)
+	}
+	return result
+}
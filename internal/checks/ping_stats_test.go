@@ -0,0 +1,124 @@
+package checks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewPingStats(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name    string
+		sent    int
+		replies []pingReply
+		// received, duplicates and outOfOrder are counts, the rest are
+		// milliseconds except loss, which is a percentage.
+		received, duplicates, outOfOrder int
+		loss, min, avg, max, stddev      float64
+		jitter                           float64
+		mos                              float64
+	}{
+		{
+			name:     "all lost",
+			sent:     4,
+			received: 0,
+			loss:     100,
+		},
+		{
+			name:     "single reply",
+			sent:     4,
+			replies:  []pingReply{{seq: 1, rtt: 20 * ms}},
+			received: 1,
+			loss:     75,
+			min:      20, avg: 20, max: 20,
+			mos: 1,
+		},
+		{
+			name:     "steady",
+			sent:     3,
+			replies:  []pingReply{{seq: 1, rtt: 10 * ms}, {seq: 2, rtt: 10 * ms}, {seq: 3, rtt: 10 * ms}},
+			received: 3,
+			min:      10, avg: 10, max: 10,
+			mos: 4.4,
+		},
+		{
+			name:     "varying with loss",
+			sent:     4,
+			replies:  []pingReply{{seq: 1, rtt: 10 * ms}, {seq: 2, rtt: 20 * ms}, {seq: 3, rtt: 30 * ms}},
+			received: 3,
+			loss:     25,
+			min:      10, avg: 20, max: 30, stddev: 8.164,
+			// 10/16, then 0.625 + (10 - 0.625) / 16.
+			jitter: 1.21,
+			mos:    1.6,
+		},
+		{
+			name: "duplicates and reordering",
+			sent: 3,
+			replies: []pingReply{
+				{seq: 1, rtt: 10 * ms},
+				{seq: 1, rtt: 50 * ms, duplicate: true},
+				{seq: 3, rtt: 30 * ms},
+				{seq: 2, rtt: 20 * ms, outOfOrder: true},
+			},
+			received: 3, duplicates: 1, outOfOrder: 1,
+			min: 10, avg: 20, max: 30, stddev: 8.164,
+			// Jitter follows arrival order: 20/16, then 1.25 + (10 - 1.25) / 16.
+			jitter: 1.796,
+			mos:    4.39,
+		},
+		{
+			name:     "high latency",
+			sent:     2,
+			replies:  []pingReply{{seq: 1, rtt: 200 * ms}, {seq: 2, rtt: 200 * ms}},
+			received: 2,
+			min:      200, avg: 200, max: 200,
+			mos: 4.17,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := newPingStats(tt.sent, tt.replies)
+
+			if stats.sent != tt.sent || stats.received != tt.received || stats.duplicates != tt.duplicates || stats.outOfOrder != tt.outOfOrder {
+				t.Fatalf("got sent %d received %d duplicates %d outOfOrder %d, want %d %d %d %d",
+					stats.sent, stats.received, stats.duplicates, stats.outOfOrder, tt.sent, tt.received, tt.duplicates, tt.outOfOrder)
+			}
+
+			got := map[string]float64{
+				"loss":   stats.loss,
+				"min":    durationMillis(stats.min),
+				"avg":    durationMillis(stats.avg),
+				"max":    durationMillis(stats.max),
+				"stddev": durationMillis(stats.stddev),
+				"jitter": durationMillis(stats.jitter),
+			}
+			want := map[string]float64{
+				"loss":   tt.loss,
+				"min":    tt.min,
+				"avg":    tt.avg,
+				"max":    tt.max,
+				"stddev": tt.stddev,
+				"jitter": tt.jitter,
+			}
+			for key := range want {
+				if got[key] != want[key] {
+					t.Errorf("%s: got %v, want %v", key, got[key], want[key])
+				}
+			}
+
+			mos, ok := stats.payload()["mos"]
+			if tt.received == 0 {
+				if ok {
+					t.Fatalf("expected no mos without replies, got %v", mos)
+				}
+				return
+			}
+			if mos != tt.mos {
+				t.Fatalf("mos: got %v, want %v", mos, tt.mos)
+			}
+		})
+	}
+}
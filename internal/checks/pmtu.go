@@ -0,0 +1,400 @@
+package checks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+const (
+	pmtuMinIPv4 = 68
+	pmtuMinIPv6 = 1280
+	pmtuMaxSize = 65535
+	// pmtuProbeTTL is set explicitly, so the TTL quoted in an error tells
+	// how far away the router that sent it is.
+	pmtuProbeTTL = 64
+)
+
+type pmtuConfig struct {
+	protocol string
+	// port is the destination port of UDP probes.
+	port     int
+	minSize  int
+	maxSize  int
+	attempts int
+	timeout  time.Duration
+}
+
+// pmtuProbe is the outcome of probing one packet size. result is "ok" when
+// the destination answered, "too_big" when a router sent fragmentation
+// needed or packet too big, "local" when the own interface refused the size
+// and "timeout" when nothing came back.
+type pmtuProbe struct {
+	size     int
+	result   string
+	attempts int
+	from     net.IP
+	rtt      time.Duration
+	// mtu is the next-hop MTU reported by the router or the interface.
+	mtu int
+	// hop is the distance of the reporting router, estimated from the TTL
+	// quoted in its error. 0 if unknown.
+	hop int
+}
+
+type pmtuReply struct {
+	// seq identifies echo probes, size UDP probes; 0 means the reply does
+	// not tell which probe it belongs to.
+	seq  int
+	size int
+	kind string
+	from net.IP
+	mtu  int
+	hop  int
+	at   time.Time
+
+	icmpType int
+	icmpCode int
+}
+
+// pmtuProber sends probes with fragmentation disabled and reads the answers
+// from a raw ICMP socket. Probes are sent one at a time.
+type pmtuProber struct {
+	config   pmtuConfig
+	dst      net.IP
+	ipv6     bool
+	localMTU int
+	icmp     *icmpSocket
+	udp      *net.UDPConn
+	udpPort  int
+
+	seq     int
+	replies chan pmtuReply
+	done    chan struct{}
+}
+
+func newPMTUProber(dst net.IP, localMTU int, config pmtuConfig) (*pmtuProber, error) {
+	p := &pmtuProber{
+		config:   config,
+		dst:      dst,
+		ipv6:     dst.To4() == nil,
+		localMTU: localMTU,
+		replies:  make(chan pmtuReply, 64),
+		done:     make(chan struct{}),
+	}
+
+	socket, err := listenRawICMP(p.ipv6)
+	if err != nil {
+		return nil, rawSocketError("pmtu", err)
+	}
+	p.icmp = socket
+
+	conn := socket.conn
+	if config.protocol == "udp" {
+		network := "udp4"
+		if p.ipv6 {
+			network = "udp6"
+		}
+		if p.udp, err = net.ListenUDP(network, nil); err != nil {
+			socket.Close()
+			return nil, err
+		}
+		p.udpPort = p.udp.LocalAddr().(*net.UDPAddr).Port
+		conn = p.udp
+	}
+
+	if err := setTTL(conn, p.ipv6, pmtuProbeTTL); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if err := setProbeMTU(conn, p.ipv6); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("set don't fragment: %w", err)
+	}
+
+	go p.receive()
+	if p.udp != nil {
+		go p.receiveUDP()
+	}
+	return p, nil
+}
+
+func (p *pmtuProber) Close() {
+	if p.udp != nil {
+		p.udp.Close()
+	}
+	p.icmp.Close()
+}
+
+// search returns the largest size between minSize and maxSize that reaches
+// the destination, and every probe it took to find it. maxSize is tried
+// first, since most paths are not limited below it; after that the range is
+// halved, except that an MTU reported by a router is tried directly, followed
+// by the size just above it.
+func (p *pmtuProber) search() (int, []pmtuProbe, error) {
+	var probes []pmtuProbe
+	probe := func(size int) (pmtuProbe, error) {
+		result, err := p.probe(size)
+		if err == nil {
+			probes = append(probes, result)
+		}
+		return result, err
+	}
+
+	first, err := probe(p.config.maxSize)
+	if err != nil {
+		return 0, probes, err
+	}
+	if first.result == "ok" {
+		return p.config.maxSize, probes, nil
+	}
+
+	if p.config.minSize >= p.config.maxSize {
+		return 0, probes, fmt.Errorf("no answer to %d byte probes", p.config.maxSize)
+	}
+	smallest, err := probe(p.config.minSize)
+	if err != nil {
+		return 0, probes, err
+	}
+	if smallest.result != "ok" {
+		return 0, probes, fmt.Errorf("no answer to %d byte probes", p.config.minSize)
+	}
+
+	low, high := p.config.minSize, p.config.maxSize
+	next := first.mtu
+	for high-low > 1 {
+		size := (low + high) / 2
+		hinted := next > low && next < high
+		if hinted {
+			size = next
+		}
+		next = 0
+
+		result, err := probe(size)
+		if err != nil {
+			return low, probes, err
+		}
+		if result.result == "ok" {
+			low = size
+			if hinted {
+				next = size + 1
+			}
+		} else {
+			high = size
+			next = result.mtu
+		}
+	}
+	return low, probes, nil
+}
+
+// probe sends up to config.attempts packets of size bytes and returns the
+// first answer.
+func (p *pmtuProber) probe(size int) (pmtuProbe, error) {
+	result := pmtuProbe{size: size, result: "timeout"}
+
+	for attempt := 1; attempt <= p.config.attempts; attempt++ {
+		result.attempts = attempt
+		p.seq = p.seq%0xffff + 1
+
+		sent := time.Now()
+		if err := p.send(size, p.seq); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				result.result = "local"
+				result.mtu = p.localMTU
+				return result, nil
+			}
+			return result, err
+		}
+
+		timer := time.NewTimer(p.config.timeout)
+	wait:
+		for {
+			select {
+			case reply := <-p.replies:
+				if !p.matches(reply, size) {
+					continue
+				}
+				timer.Stop()
+				if reply.kind == "unreachable" {
+					return result, fmt.Errorf("destination unreachable (type %d, code %d) reported by %s", reply.icmpType, reply.icmpCode, reply.from)
+				}
+				result.result = reply.kind
+				result.from = reply.from
+				result.rtt = reply.at.Sub(sent)
+				result.mtu = reply.mtu
+				result.hop = reply.hop
+				return result, nil
+			case <-timer.C:
+				break wait
+			case <-p.done:
+				timer.Stop()
+				return result, errors.New("icmp socket closed")
+			}
+		}
+	}
+	return result, nil
+}
+
+func (p *pmtuProber) matches(reply pmtuReply, size int) bool {
+	if p.config.protocol == "icmp" {
+		return reply.seq == p.seq
+	}
+	return reply.size == size || reply.size == 0
+}
+
+// send writes one probe whose IP packet is size bytes long.
+func (p *pmtuProber) send(size, seq int) error {
+	headers := 20 + 8
+	if p.ipv6 {
+		headers = 40 + 8
+	}
+	data := make([]byte, size-headers)
+
+	if p.udp != nil {
+		_, err := p.udp.WriteToUDP(data, &net.UDPAddr{IP: p.dst, Port: p.config.port})
+		return err
+	}
+	return p.icmp.sendEcho(p.dst, seq, data)
+}
+
+func (p *pmtuProber) receive() {
+	defer close(p.done)
+
+	buf := make([]byte, pmtuMaxSize+1)
+	for {
+		n, from, err := p.icmp.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		addr, ok := from.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		if reply, ok := p.parse(buf[:n], addr.IP); ok {
+			p.deliver(reply)
+		}
+	}
+}
+
+// receiveUDP reads answers of a service listening on the probed UDP port.
+func (p *pmtuProber) receiveUDP() {
+	buf := make([]byte, pmtuMaxSize+1)
+	for {
+		_, from, err := p.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if from.IP.Equal(p.dst) {
+			p.deliver(pmtuReply{kind: "ok", from: p.dst, at: time.Now()})
+		}
+	}
+}
+
+func (p *pmtuProber) deliver(reply pmtuReply) {
+	select {
+	case p.replies <- reply:
+	default:
+	}
+}
+
+// parse turns an echo reply or an ICMP error quoting one of the probes into
+// a reply. Errors from the destination itself, such as port unreachable,
+// mean that the probe got through.
+func (p *pmtuProber) parse(b []byte, from net.IP) (pmtuReply, bool) {
+	message, err := icmp.ParseMessage(p.icmp.protocol(), b)
+	if err != nil {
+		return pmtuReply{}, false
+	}
+
+	reply := pmtuReply{
+		from:     from,
+		at:       time.Now(),
+		icmpType: icmpTypeNumber(message.Type),
+		icmpCode: message.Code,
+	}
+
+	var quoted []byte
+	switch body := message.Body.(type) {
+	case *icmp.Echo:
+		echo, ok := p.icmp.echoReply(message)
+		if !ok || p.config.protocol != "icmp" || !from.Equal(p.dst) {
+			return pmtuReply{}, false
+		}
+		reply.seq, reply.kind = echo.Seq, "ok"
+		return reply, true
+	case *icmp.DstUnreach:
+		quoted, reply.kind = body.Data, "unreachable"
+		// Fragmentation needed carries the next-hop MTU in the otherwise
+		// unused header word (RFC 1191).
+		if !p.ipv6 && message.Code == 4 && len(b) >= 8 {
+			reply.kind = "too_big"
+			reply.mtu = int(binary.BigEndian.Uint16(b[6:8]))
+		}
+	case *icmp.PacketTooBig:
+		quoted, reply.kind, reply.mtu = body.Data, "too_big", body.MTU
+	default:
+		return pmtuReply{}, false
+	}
+
+	proto, dst, transport, ok := quotedPacket(quoted, p.ipv6)
+	if !ok || !dst.Equal(p.dst) || len(transport) < 8 {
+		return pmtuReply{}, false
+	}
+	switch {
+	case p.config.protocol == "icmp" && proto == p.icmp.protocol():
+		if int(binary.BigEndian.Uint16(transport[4:6])) != p.icmp.id {
+			return pmtuReply{}, false
+		}
+		reply.seq = int(binary.BigEndian.Uint16(transport[6:8]))
+	case p.config.protocol == "udp" && proto == protocolUDP:
+		if int(binary.BigEndian.Uint16(transport[0:2])) != p.udpPort {
+			return pmtuReply{}, false
+		}
+	default:
+		return pmtuReply{}, false
+	}
+
+	// Routers quote the header as they received it, before decrementing the
+	// TTL.
+	var ttl int
+	if p.ipv6 {
+		reply.size = 40 + int(binary.BigEndian.Uint16(quoted[4:6]))
+		ttl = int(quoted[7])
+	} else {
+		reply.size = int(binary.BigEndian.Uint16(quoted[2:4]))
+		ttl = int(quoted[8])
+	}
+	if ttl > 0 && ttl <= pmtuProbeTTL {
+		reply.hop = pmtuProbeTTL - ttl + 1
+	}
+
+	if reply.kind == "unreachable" && from.Equal(p.dst) {
+		reply.kind = "ok"
+	}
+	return reply, true
+}
+
+// interfaceMTU returns the MTU of the interface that owns ip, or 0.
+func interfaceMTU(ip net.IP) int {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.MTU
+			}
+		}
+	}
+	return 0
+}
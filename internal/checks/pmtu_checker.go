@@ -0,0 +1,212 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ozzus/agent-aeza/internal/domain"
+)
+
+const (
+	pmtuDefaultAttempts = 2
+	pmtuMaxAttempts     = 5
+)
+
+// PMTUChecker finds the path MTU to a target by binary-searching the size of
+// probes that must not be fragmented, and the router that reports the limit.
+// Paths that drop large packets without reporting it (MTU black holes) are
+// what breaks HTTP while ping with small packets still works.
+type PMTUChecker struct {
+	baseMetadata
+	timeout time.Duration
+	names   *reverseNames
+}
+
+func NewPMTUChecker(timeout time.Duration, location, country string) *PMTUChecker {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	return &PMTUChecker{
+		baseMetadata: newBaseMetadata(location, country),
+		timeout:      timeout,
+		names:        newReverseNames(),
+	}
+}
+
+func (p *PMTUChecker) Check(target string, parameters map[string]interface{}) (*domain.CheckResult, error) {
+	host, err := normalizeHostname(target)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	ipVersion := intParam(parameters, "ip_version", 0)
+	if ipVersion != 0 && ipVersion != 4 && ipVersion != 6 {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: "ip_version must be 4 or 6"}, nil
+	}
+
+	config, err := p.parseConfig(parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	dst, err := resolveTarget(ctx, host, ipVersion)
+	cancel()
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	localMTU := 0
+	if src, err := sourceAddress(dst); err == nil {
+		localMTU = interfaceMTU(src)
+	}
+
+	config.minSize = pmtuMinIPv4
+	if dst.To4() == nil {
+		config.minSize = pmtuMinIPv6
+	}
+	if config.maxSize == 0 {
+		switch {
+		case localMTU <= 0:
+			config.maxSize = 1500
+		case localMTU > pmtuMaxSize:
+			config.maxSize = pmtuMaxSize
+		default:
+			config.maxSize = localMTU
+		}
+	}
+	if config.maxSize < config.minSize {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("max_mtu must be at least %d", config.minSize)}, nil
+	}
+
+	prober, err := newPMTUProber(dst, localMTU, config)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+	defer prober.Close()
+
+	start := time.Now()
+	mtu, probes, searchErr := prober.search()
+	elapsed := time.Since(start)
+
+	// The limit is the smallest size that did not get through.
+	var limit *pmtuProbe
+	for i := range probes {
+		if probes[i].result != "ok" && probes[i].size > mtu && (limit == nil || probes[i].size < limit.size) {
+			limit = &probes[i]
+		}
+	}
+
+	details := addressDetails{ipdb: p.ipdb}
+	if reverseDNS, dnsTimeout := reverseDNSParams(parameters); reverseDNS && limit != nil && limit.from != nil {
+		details.names = p.names.lookup([]string{limit.from.String()}, dnsTimeout)
+	}
+
+	entry := map[string]interface{}{
+		"location":  p.locationValue(parameters),
+		"country":   p.countryValue(parameters),
+		"ip":        dst.String(),
+		"protocol":  config.protocol,
+		"maxMtu":    config.maxSize,
+		"blackHole": searchErr == nil && limit != nil && limit.result == "timeout",
+		"probes":    pmtuProbesPayload(probes),
+		"time":      formatSeconds(elapsed),
+	}
+	if config.protocol == "udp" {
+		entry["port"] = config.port
+	}
+	if localMTU > 0 {
+		entry["localMtu"] = localMTU
+	}
+	if limit != nil && limit.result == "too_big" {
+		hop := map[string]interface{}{
+			"ip":  limit.from.String(),
+			"mtu": limit.mtu,
+		}
+		if limit.hop > 0 {
+			hop["hop"] = limit.hop
+		}
+		details.add(hop, limit.from.String())
+		entry["hop"] = hop
+	}
+
+	status := domain.StatusSuccess
+	var errText string
+	if searchErr != nil {
+		status = domain.StatusFailed
+		errText = searchErr.Error()
+		entry["result"] = "FAILED"
+	} else {
+		entry["mtu"] = mtu
+		entry["result"] = "OK"
+	}
+
+	payload := map[string]interface{}{
+		"pmtu": []map[string]interface{}{entry},
+	}
+
+	return &domain.CheckResult{
+		Status:  status,
+		Error:   errText,
+		Payload: payload,
+	}, nil
+}
+
+// parseConfig reads protocol, port, max_mtu, attempts and timeout, which is
+// how long to wait for the answer to each packet.
+func (p *PMTUChecker) parseConfig(parameters map[string]interface{}) (pmtuConfig, error) {
+	config := pmtuConfig{
+		protocol: lowerStringParam(parameters, "protocol", "icmp"),
+		port:     intParam(parameters, "port", traceBasePort),
+		maxSize:  intParam(parameters, "max_mtu", 0),
+		attempts: intParam(parameters, "attempts", pmtuDefaultAttempts),
+		timeout:  durationParam(parameters, "timeout", p.timeout),
+	}
+
+	if config.protocol != "icmp" && config.protocol != "udp" {
+		return config, fmt.Errorf("unsupported protocol: %s", config.protocol)
+	}
+	if config.port <= 0 || config.port > 65535 {
+		return config, fmt.Errorf("invalid port: %d", config.port)
+	}
+	if config.maxSize < 0 || config.maxSize > pmtuMaxSize {
+		return config, fmt.Errorf("max_mtu must be at most %d", pmtuMaxSize)
+	}
+	if config.attempts <= 0 {
+		config.attempts = pmtuDefaultAttempts
+	}
+	if config.attempts > pmtuMaxAttempts {
+		return config, fmt.Errorf("attempts must be at most %d", pmtuMaxAttempts)
+	}
+	if config.timeout <= 0 {
+		config.timeout = p.timeout
+	}
+
+	return config, nil
+}
+
+func pmtuProbesPayload(probes []pmtuProbe) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(probes))
+	for _, probe := range probes {
+		entry := map[string]interface{}{
+			"size":     probe.size,
+			"result":   probe.result,
+			"attempts": probe.attempts,
+		}
+		if probe.from != nil {
+			entry["ip"] = probe.from.String()
+			entry["time"] = durationMillis(probe.rtt)
+		}
+		if probe.mtu > 0 {
+			entry["mtu"] = probe.mtu
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func (p *PMTUChecker) Type() domain.TaskType {
+	return domain.TaskTypePMTU
+}
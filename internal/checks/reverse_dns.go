@@ -0,0 +1,136 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	reverseDNSTimeout     = time.Second
+	reverseDNSConcurrency = 16
+	reverseDNSCacheTTL    = time.Hour
+	reverseDNSNegativeTTL = 5 * time.Minute
+	reverseDNSCacheSize   = 10000
+)
+
+// reverseNames resolves and caches PTR names of the addresses reported by
+// ping, traceroute and mtr.
+type reverseNames struct {
+	mu      sync.Mutex
+	entries map[string]reverseEntry
+}
+
+type reverseEntry struct {
+	name    string
+	expires time.Time
+}
+
+func newReverseNames() *reverseNames {
+	return &reverseNames{entries: make(map[string]reverseEntry)}
+}
+
+// reverseDNSParams reads reverse_dns and reverse_dns_timeout, the time all
+// lookups of one check may take together.
+func reverseDNSParams(parameters map[string]interface{}) (bool, time.Duration) {
+	timeout := durationParam(parameters, "reverse_dns_timeout", reverseDNSTimeout)
+	if timeout <= 0 {
+		timeout = reverseDNSTimeout
+	}
+	return boolParam(parameters, "reverse_dns", false), timeout
+}
+
+// lookup returns the PTR names of addrs, resolving the ones not cached yet
+// concurrently. Addresses without a name, or whose lookup did not finish
+// within timeout, map to "".
+func (r *reverseNames) lookup(addrs []string, timeout time.Duration) map[string]string {
+	result := make(map[string]string, len(addrs))
+	var pending []string
+
+	now := time.Now()
+	r.mu.Lock()
+	for _, addr := range addrs {
+		if _, seen := result[addr]; seen || net.ParseIP(addr) == nil {
+			continue
+		}
+		if entry, ok := r.entries[addr]; ok && now.Before(entry.expires) {
+			result[addr] = entry.name
+			continue
+		}
+		result[addr] = ""
+		pending = append(pending, addr)
+	}
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, reverseDNSConcurrency)
+	for _, addr := range pending {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			if name, ok := r.resolve(ctx, addr); ok {
+				mu.Lock()
+				result[addr] = name
+				mu.Unlock()
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	return result
+}
+
+// resolve looks up one address and caches the answer. Missing names are
+// cached for a shorter time, other errors such as timeouts are not cached.
+func (r *reverseNames) resolve(ctx context.Context, addr string) (string, bool) {
+	names, err := net.DefaultResolver.LookupAddr(ctx, addr)
+
+	var name string
+	ttl := reverseDNSCacheTTL
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil && len(names) > 0:
+		name = strings.TrimSuffix(names[0], ".")
+	case err == nil, errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		ttl = reverseDNSNegativeTTL
+	default:
+		return "", false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if len(r.entries) >= reverseDNSCacheSize {
+		for key, entry := range r.entries {
+			if now.After(entry.expires) {
+				delete(r.entries, key)
+			}
+		}
+		if len(r.entries) >= reverseDNSCacheSize {
+			r.entries = make(map[string]reverseEntry)
+		}
+	}
+	r.entries[addr] = reverseEntry{name: name, expires: now.Add(ttl)}
+
+	return name, true
+}
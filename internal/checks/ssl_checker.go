@@ -0,0 +1,257 @@
+package checks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"ozzus/agent-aeza/internal/domain"
+)
+
+type SSLChecker struct {
+	baseMetadata
+	timeout time.Duration
+}
+
+func NewSSLChecker(timeout time.Duration, location, country string) *SSLChecker {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &SSLChecker{
+		baseMetadata: newBaseMetadata(location, country),
+		timeout:      timeout,
+	}
+}
+
+func (s *SSLChecker) Check(target string, parameters map[string]interface{}) (*domain.CheckResult, error) {
+	host, address, err := s.resolveAddress(target, parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	timeout := durationParam(parameters, "timeout", s.timeout)
+	if timeout <= 0 {
+		timeout = s.timeout
+	}
+
+	serverName := stringParam(parameters, "server_name", host)
+	minDays := intParam(parameters, "min_days_valid", 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Verification is done manually after the handshake so that expired or
+	// untrusted certificates can still be inspected and reported.
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{},
+		Config: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		},
+	}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	duration := time.Since(start)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return &domain.CheckResult{
+			Status: domain.StatusFailed,
+			Error:  err.Error(),
+			Payload: map[string]interface{}{
+				"ssl": []map[string]interface{}{
+					{
+						"location":      s.locationValue(parameters),
+						"country":       s.countryValue(parameters),
+						"ip":            host,
+						"handshakeTime": formatSeconds(duration),
+						"result":        "FAILED",
+					},
+				},
+			},
+		}, nil
+	}
+	defer conn.Close()
+
+	tlsConn := conn.(*tls.Conn)
+	state := tlsConn.ConnectionState()
+
+	ip := host
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = tcpAddr.IP.String()
+	}
+
+	leaf := state.PeerCertificates[0]
+	now := time.Now()
+	daysLeft := daysUntil(leaf.NotAfter, now)
+
+	verifyErr := s.verifyChain(state.PeerCertificates, serverName, now)
+
+	chain := make([]map[string]interface{}, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		chain = append(chain, describeCertificate(cert, now))
+	}
+
+	status := domain.StatusSuccess
+	resultText := "OK"
+	var errText string
+
+	switch {
+	case verifyErr != nil:
+		status = domain.StatusFailed
+		resultText = "FAILED"
+		errText = verifyErr.Error()
+	case minDays > 0 && daysLeft < minDays:
+		status = domain.StatusFailed
+		resultText = "EXPIRING"
+		errText = fmt.Sprintf("certificate expires in %d days (minimum %d)", daysLeft, minDays)
+	}
+
+	verifyText := ""
+	if verifyErr != nil {
+		verifyText = verifyErr.Error()
+	}
+
+	entry := map[string]interface{}{
+		"location":      s.locationValue(parameters),
+		"country":       s.countryValue(parameters),
+		"ip":            ip,
+		"serverName":    serverName,
+		"handshakeTime": formatSeconds(duration),
+		"tlsVersion":    tls.VersionName(state.Version),
+		"cipherSuite":   tls.CipherSuiteName(state.CipherSuite),
+		"alpn":          state.NegotiatedProtocol,
+		"verified":      verifyErr == nil,
+		"verifyError":   verifyText,
+		"daysToExpiry":  daysLeft,
+		"leaf":          chain[0],
+		"chain":         chain,
+		"result":        resultText,
+	}
+	if info := s.ipInfo(ip); info != nil {
+		entry["ipInfo"] = info
+	}
+
+	payload := map[string]interface{}{
+		"ssl": []map[string]interface{}{entry},
+	}
+
+	return &domain.CheckResult{
+		Status:  status,
+		Error:   errText,
+		Payload: payload,
+	}, nil
+}
+
+func (s *SSLChecker) verifyChain(certs []*x509.Certificate, serverName string, now time.Time) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	return err
+}
+
+func (s *SSLChecker) resolveAddress(target string, parameters map[string]interface{}) (string, string, error) {
+	trimmed := strings.TrimSpace(target)
+	if trimmed == "" {
+		return "", "", fmt.Errorf("empty target")
+	}
+
+	port := stringParam(parameters, "port", "443")
+
+	if strings.Contains(trimmed, "://") {
+		parsed, err := url.Parse(trimmed)
+		if err != nil {
+			return "", "", err
+		}
+		if parsed.Port() != "" {
+			port = parsed.Port()
+		}
+		return parsed.Hostname(), net.JoinHostPort(parsed.Hostname(), port), nil
+	}
+
+	if host, p, err := net.SplitHostPort(trimmed); err == nil {
+		return host, net.JoinHostPort(host, p), nil
+	}
+
+	host, err := normalizeHostname(trimmed)
+	if err != nil {
+		return "", "", err
+	}
+
+	return host, net.JoinHostPort(host, port), nil
+}
+
+func describeCertificate(cert *x509.Certificate, now time.Time) map[string]interface{} {
+	keyType, keySize := publicKeyInfo(cert)
+
+	return map[string]interface{}{
+		"subject":            cert.Subject.String(),
+		"commonName":         cert.Subject.CommonName,
+		"issuer":             cert.Issuer.String(),
+		"serialNumber":       cert.SerialNumber.Text(16),
+		"sans":               certificateSANs(cert),
+		"notBefore":          cert.NotBefore.UTC().Format(time.RFC3339),
+		"notAfter":           cert.NotAfter.UTC().Format(time.RFC3339),
+		"daysToExpiry":       daysUntil(cert.NotAfter, now),
+		"keyType":            keyType,
+		"keySize":            keySize,
+		"signatureAlgorithm": cert.SignatureAlgorithm.String(),
+		"isCA":               cert.IsCA,
+	}
+}
+
+// daysUntil counts whole days from now to t, rounding down, so a certificate
+// that expired hours ago is -1 days from expiry rather than 0.
+func daysUntil(t, now time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
+
+func (s *SSLChecker) Type() domain.TaskType {
+	return domain.TaskTypeSSL
+}
@@ -3,6 +3,7 @@ package checks
 import (
 	"context"
 	"fmt"
+	"math"
 	"net"
 	"net/url"
 	"strings"
@@ -11,6 +12,11 @@ import (
 	"ozzus/agent-aeza/internal/domain"
 )
 
+const (
+	tcpMaxCount    = 100
+	tcpMaxInterval = 10 * time.Second
+)
+
 type TCPChecker struct {
 	baseMetadata
 	timeout time.Duration
@@ -38,34 +44,66 @@ func (t *TCPChecker) Check(target string, parameters map[string]interface{}) (*d
 		timeout = t.timeout
 	}
 
+	hostOnly := address
+	if host, _, splitErr := net.SplitHostPort(address); splitErr == nil {
+		hostOnly = host
+	}
+
+	dialOpts, err := parseDialOptions(parameters, hostOnly)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+	dialAddress := dialOpts.address(address)
+	if host, _, splitErr := net.SplitHostPort(dialAddress); splitErr == nil {
+		hostOnly = host
+	}
+
+	d := &net.Dialer{}
+	proxyDial, err := parseProxy(parameters, d, dialOpts)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+	dial := dialOpts.dialContext(d)
+	if proxyDial != nil {
+		dial = proxyDial.DialContext
+	}
+
+	if count := intParam(parameters, "count", 1); count > 1 {
+		if count > tcpMaxCount {
+			return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("count must be at most %d", tcpMaxCount)}, nil
+		}
+		interval := durationParam(parameters, "interval", 0)
+		if interval < 0 || interval > tcpMaxInterval {
+			return &domain.CheckResult{Status: domain.StatusFailed, Error: fmt.Sprintf("interval must be between 0 and %s", tcpMaxInterval)}, nil
+		}
+		return t.checkRepeated(context.Background(), parameters, dial, address, hostOnly, proxyDial, count, interval, timeout), nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	d := net.Dialer{}
 	start := time.Now()
-	conn, err := d.DialContext(ctx, "tcp", address)
+	conn, err := dial(ctx, "tcp", address)
 	duration := time.Since(start)
 
-	hostOnly := address
-	if host, _, splitErr := net.SplitHostPort(address); splitErr == nil {
-		hostOnly = host
-	}
-
 	if err != nil {
 		if ctx.Err() != nil {
 			err = ctx.Err()
 		}
 
+		entry := map[string]interface{}{
+			"location":    t.locationValue(parameters),
+			"country":     t.countryValue(parameters),
+			"connectTime": formatSeconds(duration),
+			"status":      "Failed",
+			"ip":          hostOnly,
+		}
+		if proxyDial != nil {
+			entry["proxy"] = proxyDial.payload()
+		}
+
 		payload := map[string]interface{}{
-			"tcp": []map[string]interface{}{
-				{
-					"location":    t.locationValue(parameters),
-					"country":     t.countryValue(parameters),
-					"connectTime": formatSeconds(duration),
-					"status":      "Failed",
-					"ip":          hostOnly,
-				},
-			},
+			"tcp": []map[string]interface{}{entry},
 		}
 
 		return &domain.CheckResult{
@@ -78,22 +116,28 @@ func (t *TCPChecker) Check(target string, parameters map[string]interface{}) (*d
 
 	remoteAddr := conn.RemoteAddr()
 	ip := hostOnly
-	if tcpAddr, ok := remoteAddr.(*net.TCPAddr); ok {
+	if tcpAddr, ok := remoteAddr.(*net.TCPAddr); ok && proxyDial == nil {
 		ip = tcpAddr.IP.String()
 	}
 
 	duration = time.Since(start)
 
+	entry := map[string]interface{}{
+		"location":    t.locationValue(parameters),
+		"country":     t.countryValue(parameters),
+		"connectTime": formatSeconds(duration),
+		"status":      "Connected",
+		"ip":          ip,
+	}
+	if info := t.ipInfo(ip); info != nil {
+		entry["ipInfo"] = info
+	}
+	if proxyDial != nil {
+		entry["proxy"] = proxyDial.payload()
+	}
+
 	payload := map[string]interface{}{
-		"tcp": []map[string]interface{}{
-			{
-				"location":    t.locationValue(parameters),
-				"country":     t.countryValue(parameters),
-				"connectTime": formatSeconds(duration),
-				"status":      "Connected",
-				"ip":          ip,
-			},
-		},
+		"tcp": []map[string]interface{}{entry},
 	}
 
 	return &domain.CheckResult{
@@ -102,6 +146,105 @@ func (t *TCPChecker) Check(target string, parameters map[string]interface{}) (*d
 	}, nil
 }
 
+// checkRepeated connects count times, interval apart, and reports handshake
+// latency statistics. It is used as a TCP ping for targets that drop ICMP.
+// All attempts share one deadline, so a silent port cannot stretch the check
+// to count timeouts. Attempts the deadline leaves no time for are not made,
+// and the statistics only cover the attempts that were. The deadline is
+// derived from ctx, so cancelling ctx ends the check the same way.
+func (t *TCPChecker) checkRepeated(ctx context.Context, parameters map[string]interface{}, dial func(ctx context.Context, network, addr string) (net.Conn, error), address, ip string, proxyDial *proxyDialer, count int, interval, timeout time.Duration) *domain.CheckResult {
+	total, cancelTotal := context.WithTimeout(ctx, time.Duration(count-1)*interval+timeout)
+	defer cancelTotal()
+
+	var attempts []map[string]interface{}
+	var replies []pingReply
+	var lastErr error
+
+	for seq := 1; seq <= count && total.Err() == nil; seq++ {
+		attemptCtx, cancel := context.WithTimeout(total, timeout)
+		start := time.Now()
+		conn, err := dial(attemptCtx, "tcp", address)
+		rtt := time.Since(start)
+		cancel()
+
+		if err != nil && total.Err() != nil && rtt < timeout {
+			// The shared deadline cut the attempt short, it did not time out.
+			break
+		}
+
+		attempt := map[string]interface{}{
+			"seq":       seq,
+			"connected": err == nil,
+			"time":      durationMillis(rtt),
+		}
+		if err != nil {
+			lastErr = err
+			attempt["error"] = err.Error()
+		} else {
+			if proxyDial == nil {
+				ip = remoteIP(conn.RemoteAddr())
+			}
+			conn.Close()
+			replies = append(replies, pingReply{seq: seq, rtt: rtt})
+		}
+		attempts = append(attempts, attempt)
+
+		if seq < count && interval > 0 {
+			timer := time.NewTimer(time.Until(start.Add(interval)))
+			select {
+			case <-timer.C:
+			case <-total.Done():
+				timer.Stop()
+			}
+		}
+	}
+
+	stats := newPingStats(len(attempts), replies)
+
+	status := "Connected"
+	if stats.received == 0 {
+		status = "Failed"
+	}
+
+	entry := map[string]interface{}{
+		"location":    t.locationValue(parameters),
+		"country":     t.countryValue(parameters),
+		"connectTime": formatSeconds(stats.avg),
+		"status":      status,
+		"ip":          ip,
+		"attempts":    attempts,
+		"statistics": map[string]interface{}{
+			"min":         durationMillis(stats.min),
+			"avg":         durationMillis(stats.avg),
+			"max":         durationMillis(stats.max),
+			"stddev":      durationMillis(stats.stddev),
+			"failures":    len(attempts) - stats.received,
+			"failureRate": math.Round(stats.loss*100) / 100,
+		},
+	}
+	if info := t.ipInfo(ip); info != nil {
+		entry["ipInfo"] = info
+	}
+	if proxyDial != nil {
+		entry["proxy"] = proxyDial.payload()
+	}
+
+	result := &domain.CheckResult{
+		Status: domain.StatusSuccess,
+		Payload: map[string]interface{}{
+			"tcp": []map[string]interface{}{entry},
+		},
+	}
+	if stats.received == 0 {
+		if lastErr == nil {
+			lastErr = total.Err()
+		}
+		result.Status = domain.StatusFailed
+		result.Error = lastErr.Error()
+	}
+	return result
+}
+
 func (t *TCPChecker) resolveAddress(target string, parameters map[string]interface{}) (string, error) {
 	if target == "" {
 		return "", fmt.Errorf("empty target")
@@ -0,0 +1,76 @@
+package checks
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckRepeatedStopsAtDeadline(t *testing.T) {
+	tests := []struct {
+		name string
+		// cutAt is the dial call during which the shared deadline passes.
+		cutAt      int
+		attempts   int
+		wantStatus string
+	}{
+		{name: "after some attempts", cutAt: 3, attempts: 2},
+		{name: "before any attempt", cutAt: 1, attempts: 0, wantStatus: "Failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+				calls++
+				if calls == tt.cutAt {
+					// The deadline passes while this connect is still in
+					// flight, well before the attempt's own timeout.
+					cancel()
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			}
+
+			checker := NewTCPChecker(time.Second, "", "")
+			result := checker.checkRepeated(ctx, nil, dial, "127.0.0.1:80", "127.0.0.1", nil, 5, 0, time.Minute)
+
+			if calls != tt.cutAt {
+				t.Fatalf("expected %d dial calls, got %d", tt.cutAt, calls)
+			}
+
+			entry := result.Payload.(map[string]interface{})["tcp"].([]map[string]interface{})[0]
+			attempts := entry["attempts"].([]map[string]interface{})
+			if len(attempts) != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d: %v", tt.attempts, len(attempts), attempts)
+			}
+			for _, attempt := range attempts {
+				if attempt["connected"] != true {
+					t.Fatalf("unexpected failed attempt: %v", attempt)
+				}
+			}
+
+			statistics := entry["statistics"].(map[string]interface{})
+			if statistics["failures"] != 0 {
+				t.Fatalf("expected no failures, got %v", statistics)
+			}
+
+			if tt.wantStatus == "" {
+				if result.Error != "" {
+					t.Fatalf("unexpected error: %s", result.Error)
+				}
+				return
+			}
+			if entry["status"] != tt.wantStatus || result.Error == "" {
+				t.Fatalf("expected status %s with an error, got %v %q", tt.wantStatus, entry["status"], result.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,733 @@
+package checks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	traceBasePort     = 33434
+	traceRoundSpacing = 50 * time.Millisecond
+	tracePayloadSize  = 32
+
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+type traceConfig struct {
+	protocol string
+	// port is the destination port of TCP and UDP probes. UDP probes without
+	// a port use classic incrementing ports starting at traceBasePort.
+	port int
+	// mode is classic, paris or multipath. Paris keeps the flow identifier
+	// of all probes constant, multipath sends each round on its own flow.
+	mode    string
+	maxHops int
+	probes  int
+	timeout time.Duration
+}
+
+// flowStable reports whether probes keep the headers load balancers hash on.
+func (c traceConfig) flowStable() bool {
+	return c.mode == "paris" || c.mode == "multipath"
+}
+
+type traceProbe struct {
+	ttl      int
+	flow     int
+	sent     time.Time
+	replied  bool
+	from     net.IP
+	rtt      time.Duration
+	icmpType int
+	icmpCode int
+	// final is set when the destination itself answered the probe.
+	final bool
+	// unreachable is set when a router reported the destination unreachable.
+	unreachable bool
+	// portState is "open" or "closed" when the destination itself answered a
+	// TCP or UDP probe instead of an ICMP error.
+	portState string
+}
+
+// tracer sends TTL-limited probes and matches the ICMP errors they trigger,
+// which are read from a raw ICMP socket. Probes are identified by the ICMP
+// echo sequence number or by the TCP/UDP port pair quoted in the error; in
+// flow-stable modes by the UDP checksum or the TCP sequence number instead.
+type tracer struct {
+	config  traceConfig
+	dst     net.IP
+	src     net.IP
+	ipv6    bool
+	icmp    *icmpSocket
+	udp     net.PacketConn
+	udpPort int
+	raw     net.PacketConn
+	// errQueue is set when no raw ICMP socket could be opened. ICMP and UDP
+	// probes then read their ICMP errors from the error queue of the socket
+	// they were sent from (IP_RECVERR), and icmp is a datagram socket or nil.
+	errQueue bool
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu      sync.Mutex
+	probes  []*traceProbe
+	ports   map[uint32]int
+	sums    map[uint32]int
+	flows   map[int]int
+	sockets []io.Closer
+	updated chan struct{}
+	done    chan struct{}
+
+	// probeConns are the sockets of UDP probes to a fixed port, each open
+	// until its probe is answered or times out.
+	probeConns map[int]net.PacketConn
+}
+
+// newTracer opens the sockets and starts reading replies in the background
+// until Close is called.
+func newTracer(dst net.IP, config traceConfig) (*tracer, error) {
+	t := &tracer{
+		config:  config,
+		dst:     dst,
+		ipv6:    dst.To4() == nil,
+		ports:   make(map[uint32]int),
+		sums:    make(map[uint32]int),
+		flows:   make(map[int]int),
+		updated: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+
+		probeConns: make(map[int]net.PacketConn),
+	}
+
+	socket, err := listenRawICMP(t.ipv6)
+	if err != nil {
+		if socket, err = t.listenErrQueue(err); err != nil {
+			return nil, err
+		}
+	}
+	t.icmp = socket
+
+	switch {
+	case config.flowStable() && config.protocol != "icmp":
+		// UDP and TCP checksums cover the source address.
+		if t.src, err = sourceAddress(dst); err != nil {
+			t.closeICMP()
+			return nil, err
+		}
+		if t.raw, err = net.ListenPacket(t.network("ip")+":"+config.protocol, ""); err != nil {
+			t.closeICMP()
+			return nil, fmt.Errorf("raw %s socket: %w", config.protocol, err)
+		}
+	case config.protocol == "udp" && config.port == 0:
+		conn, err := t.listenUDP()
+		if err != nil {
+			t.closeICMP()
+			return nil, err
+		}
+		t.udp = conn
+		t.udpPort = conn.LocalAddr().(*net.UDPAddr).Port
+	}
+
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	go t.receive()
+	if t.raw != nil && config.protocol == "tcp" {
+		t.workers.Add(1)
+		go t.receiveTCP()
+	}
+	return t, nil
+}
+
+func (t *tracer) Close() {
+	t.cancel()
+	if t.udp != nil {
+		t.udp.Close()
+	}
+	if t.raw != nil {
+		t.raw.Close()
+	}
+	t.mu.Lock()
+	for _, socket := range t.sockets {
+		socket.Close()
+	}
+	for key := range t.probeConns {
+		t.dropProbeConn(key)
+	}
+	t.mu.Unlock()
+	t.workers.Wait()
+
+	t.closeICMP()
+	<-t.done
+}
+
+// listenErrQueue is the fallback for a failed raw ICMP socket. ICMP and UDP
+// probes can do without one; TCP probes and flow-stable UDP probes are sent
+// on raw sockets anyway.
+func (t *tracer) listenErrQueue(rawErr error) (*icmpSocket, error) {
+	if t.config.protocol == "tcp" || t.config.flowStable() && t.config.protocol != "icmp" {
+		return nil, rawSocketError(t.config.protocol+" traceroute", rawErr)
+	}
+
+	t.errQueue = true
+	if t.config.protocol != "icmp" {
+		return nil, nil
+	}
+	socket, err := listenRecvErrICMP(t.ipv6)
+	if err != nil {
+		return nil, fmt.Errorf("icmp traceroute needs CAP_NET_RAW or a group in net.ipv4.ping_group_range: %v; datagram icmp socket: %w", rawErr, err)
+	}
+	return socket, nil
+}
+
+// listenUDP opens a socket for UDP probes.
+func (t *tracer) listenUDP() (net.PacketConn, error) {
+	conn, err := net.ListenPacket(t.network("udp"), ":0")
+	if err != nil {
+		return nil, err
+	}
+	if t.errQueue {
+		if err := enableRecvErr(conn, t.ipv6); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (t *tracer) closeICMP() {
+	if t.icmp != nil {
+		t.icmp.Close()
+	}
+}
+
+func (t *tracer) network(base string) string {
+	if t.ipv6 {
+		return base + "6"
+	}
+	return base + "4"
+}
+
+// trace sends one probe per TTL in each of config.probes rounds without
+// waiting for replies, then collects answers for up to config.timeout. In
+// multipath mode every round uses a different flow.
+func (t *tracer) trace(ctx context.Context) error {
+	first := t.mark()
+
+	var sendErr error
+	for round := 0; round < t.config.probes && ctx.Err() == nil; round++ {
+		if round > 0 {
+			select {
+			case <-time.After(traceRoundSpacing):
+			case <-ctx.Done():
+			}
+		}
+		flow := 0
+		if t.config.mode == "multipath" {
+			flow = round
+		}
+		if err := t.sendRound(ctx, flow); err != nil && sendErr == nil {
+			sendErr = err
+		}
+	}
+
+	t.wait(ctx, first, t.config.timeout)
+
+	for _, probe := range t.results() {
+		if probe.replied {
+			return nil
+		}
+	}
+	return sendErr
+}
+
+// sendRound sends one probe for every TTL up to the known end of the path.
+// flow only matters in flow-stable modes.
+func (t *tracer) sendRound(ctx context.Context, flow int) error {
+	var sendErr error
+	last, _ := t.pathEnd()
+	for ttl := 1; ttl <= last && ctx.Err() == nil; ttl++ {
+		if err := t.send(ttl, flow); err != nil && sendErr == nil {
+			sendErr = err
+		}
+	}
+	return sendErr
+}
+
+// wait blocks until every probe sent since mark first is answered up to the
+// end of the path, or until timeout.
+func (t *tracer) wait(ctx context.Context, first int, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for !t.complete(first) {
+		select {
+		case <-t.updated:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// mark returns the key the next probe will get.
+func (t *tracer) mark() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.probes)
+}
+
+// results returns a copy of all probes sent so far.
+func (t *tracer) results() []traceProbe {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]traceProbe, len(t.probes))
+	for i, probe := range t.probes {
+		result[i] = *probe
+	}
+	return result
+}
+
+func (t *tracer) send(ttl, flow int) error {
+	t.mu.Lock()
+	key := len(t.probes)
+	t.probes = append(t.probes, &traceProbe{ttl: ttl, flow: flow})
+	t.mu.Unlock()
+
+	switch {
+	case t.config.flowStable() && t.config.protocol == "tcp":
+		return t.sendFlowTCP(key, ttl, flow)
+	case t.config.flowStable() && t.config.protocol == "udp":
+		return t.sendFlowUDP(key, ttl, flow)
+	case t.config.protocol == "tcp":
+		return t.sendTCP(key, ttl)
+	case t.config.protocol == "udp":
+		return t.sendUDP(key, ttl)
+	}
+
+	if err := setTTL(t.icmp.conn, t.ipv6, ttl); err != nil {
+		return err
+	}
+	data := make([]byte, tracePayloadSize)
+	if t.config.flowStable() {
+		binary.BigEndian.PutUint16(data, echoChecksumPad(key, flow))
+	}
+	t.markSent(key, 0, 0)
+	err := t.icmp.sendEcho(t.dst, key, data)
+	if err != nil && t.errQueue {
+		// The send may have failed with the socket error left by the ICMP
+		// error of an earlier probe, which is also queued.
+		err = t.icmp.sendEcho(t.dst, key, data)
+	}
+	return err
+}
+
+// markSent starts the clock of probe key and registers its port pair.
+func (t *tracer) markSent(key, srcPort, dstPort int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if srcPort > 0 {
+		t.ports[portPair(srcPort, dstPort)] = key
+	}
+	t.probes[key].sent = time.Now()
+}
+
+func (t *tracer) sendUDP(key, ttl int) error {
+	conn, dstPort := t.udp, traceBasePort+key
+	if conn == nil {
+		// With a fixed destination port every probe needs its own source port.
+		var err error
+		conn, err = t.listenUDP()
+		if err != nil {
+			return err
+		}
+		t.mu.Lock()
+		t.probeConns[key] = conn
+		t.mu.Unlock()
+		dstPort = t.config.port
+
+		t.workers.Add(1)
+		go t.receiveUDP(key, conn)
+	}
+
+	if err := setTTL(conn, t.ipv6, ttl); err != nil {
+		return err
+	}
+	t.markSent(key, conn.LocalAddr().(*net.UDPAddr).Port, dstPort)
+
+	addr := &net.UDPAddr{IP: t.dst, Port: dstPort}
+	_, err := conn.WriteTo(make([]byte, tracePayloadSize), addr)
+	if err != nil && t.errQueue && conn == t.udp {
+		// Like in send, the error may belong to an earlier probe.
+		_, err = conn.WriteTo(make([]byte, tracePayloadSize), addr)
+	}
+	return err
+}
+
+// receiveUDP waits for a UDP answer from the destination, which means a
+// service listens on the probed port, or in errQueue mode for the ICMP error
+// of the probe. The socket is closed when the probe times out, or by answer
+// when a reply of any kind arrives.
+func (t *tracer) receiveUDP(key int, conn net.PacketConn) {
+	defer t.workers.Done()
+	defer t.closeProbeConn(key)
+
+	_ = conn.SetReadDeadline(time.Now().Add(t.config.timeout))
+	buf := make([]byte, 1500)
+	for {
+		from, queued, err := t.readUDP(conn, buf)
+		if err != nil {
+			return
+		}
+		if queued != nil {
+			t.answerICMP(key, queued.from, queued.icmpType, queued.icmpCode, time.Now())
+			return
+		}
+		if from.Equal(t.dst) {
+			t.answer(key, traceProbe{from: t.dst, icmpType: -1, final: true, portState: "open"}, time.Now())
+			return
+		}
+	}
+}
+
+// readUDP returns the sender of the next datagram on conn, or in errQueue
+// mode possibly a queued ICMP error instead.
+func (t *tracer) readUDP(conn net.PacketConn, buf []byte) (net.IP, *queuedError, error) {
+	if t.errQueue {
+		_, from, queued, err := readRecvErr(conn, buf)
+		return from, queued, err
+	}
+
+	_, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if addr, ok := from.(*net.UDPAddr); ok {
+		return addr.IP, nil, nil
+	}
+	return nil, nil, nil
+}
+
+func (t *tracer) closeProbeConn(key int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropProbeConn(key)
+}
+
+// dropProbeConn closes the socket of probe key, if it has its own. t.mu must
+// be held.
+func (t *tracer) dropProbeConn(key int) {
+	if conn, ok := t.probeConns[key]; ok {
+		conn.Close()
+		delete(t.probeConns, key)
+	}
+}
+
+// sendTCP starts a connection attempt with a limited TTL. Routers answer the
+// SYN with ICMP errors, the destination completes or refuses the handshake.
+func (t *tracer) sendTCP(key, ttl int) error {
+	started := make(chan error, 1)
+	dialer := &net.Dialer{
+		Timeout: t.config.timeout,
+		Control: tcpProbeControl(ttl, t.ipv6, func(port int) {
+			t.markSent(key, port, t.config.port)
+			started <- nil
+		}),
+	}
+	address := net.JoinHostPort(t.dst.String(), strconv.Itoa(t.config.port))
+
+	t.workers.Add(1)
+	go func() {
+		defer t.workers.Done()
+
+		conn, err := dialer.DialContext(t.ctx, t.network("tcp"), address)
+		at := time.Now()
+		switch {
+		case err == nil:
+			conn.Close()
+			t.answer(key, traceProbe{from: t.dst, icmpType: -1, final: true, portState: "open"}, at)
+		case errors.Is(err, syscall.ECONNREFUSED):
+			t.answer(key, traceProbe{from: t.dst, icmpType: -1, final: true, portState: "closed"}, at)
+		default:
+			// Reports errors from before the SYN was sent.
+			select {
+			case started <- err:
+			default:
+			}
+		}
+	}()
+
+	return <-started
+}
+
+func (t *tracer) receive() {
+	defer close(t.done)
+
+	if t.errQueue {
+		t.receiveQueued()
+		return
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		message, from, err := t.icmp.read(buf)
+		if err != nil {
+			return
+		}
+		t.handle(message, from, time.Now())
+	}
+}
+
+func (t *tracer) handle(message *icmp.Message, from net.IP, at time.Time) {
+	key := -1
+	switch body := message.Body.(type) {
+	case *icmp.Echo:
+		if echo, ok := t.icmp.echoReply(message); ok && t.config.protocol == "icmp" {
+			key = echo.Seq
+		}
+	case *icmp.TimeExceeded:
+		key = t.match(body.Data)
+	case *icmp.DstUnreach:
+		key = t.match(body.Data)
+	case *icmp.PacketTooBig:
+		key = t.match(body.Data)
+	}
+
+	t.answerICMP(key, from, icmpTypeNumber(message.Type), message.Code, at)
+}
+
+// receiveQueued replaces receive in errQueue mode. Echo replies and the
+// errors of ICMP probes arrive on the datagram ICMP socket, the errors of
+// classic UDP probes on the shared UDP socket. UDP probes to a fixed port
+// have a socket each, which receiveUDP reads.
+func (t *tracer) receiveQueued() {
+	var conn net.PacketConn
+	switch {
+	case t.icmp != nil:
+		conn = t.icmp.conn
+	case t.udp != nil:
+		conn = t.udp
+	default:
+		<-t.ctx.Done()
+		return
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, queued, err := readRecvErr(conn, buf)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+
+		switch {
+		case queued != nil:
+			t.answerICMP(t.matchQueued(queued), queued.from, queued.icmpType, queued.icmpCode, at)
+		case t.icmp != nil:
+			if message, err := icmp.ParseMessage(t.icmp.protocol(), buf[:n]); err == nil {
+				t.handle(message, from, at)
+			}
+		}
+	}
+}
+
+// matchQueued returns the key of the probe a queued ICMP error is about, or
+// -1. The error holds the echo header of ICMP probes and the destination
+// port of UDP probes.
+func (t *tracer) matchQueued(queued *queuedError) int {
+	if t.icmp != nil {
+		if len(queued.payload) < 8 {
+			return -1
+		}
+		return int(binary.BigEndian.Uint16(queued.payload[6:8]))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if key, ok := t.ports[portPair(t.udpPort, queued.port)]; ok {
+		return key
+	}
+	return -1
+}
+
+// answerICMP records an ICMP message of type icmpType from from as the
+// answer to probe key.
+func (t *tracer) answerICMP(key int, from net.IP, icmpType, icmpCode int, at time.Time) {
+	echoReply := icmpType == int(ipv4.ICMPTypeEchoReply)
+	unreachable := icmpType == int(ipv4.ICMPTypeDestinationUnreachable)
+	if t.ipv6 {
+		echoReply = icmpType == int(ipv6.ICMPTypeEchoReply)
+		unreachable = icmpType == int(ipv6.ICMPTypeDestinationUnreachable)
+	}
+	final := from.Equal(t.dst) && (echoReply || unreachable)
+
+	t.answer(key, traceProbe{
+		from:        from,
+		icmpType:    icmpType,
+		icmpCode:    icmpCode,
+		final:       final,
+		unreachable: unreachable && !final,
+	}, at)
+}
+
+// answer records the first reply to probe key and wakes up wait. Replies
+// that arrive after the probe timed out are dropped, so that late answers to
+// earlier mtr rounds do not count.
+func (t *tracer) answer(key int, reply traceProbe, at time.Time) {
+	t.mu.Lock()
+	if key < 0 || key >= len(t.probes) || t.probes[key].replied || at.Sub(t.probes[key].sent) > t.config.timeout {
+		t.mu.Unlock()
+		return
+	}
+	probe := t.probes[key]
+	probe.replied = true
+	probe.from = reply.from
+	probe.rtt = at.Sub(probe.sent)
+	probe.icmpType = reply.icmpType
+	probe.icmpCode = reply.icmpCode
+	probe.final = reply.final
+	probe.unreachable = reply.unreachable
+	probe.portState = reply.portState
+	t.dropProbeConn(key)
+	t.mu.Unlock()
+
+	select {
+	case t.updated <- struct{}{}:
+	default:
+	}
+}
+
+// match returns the key of the probe quoted in an ICMP error, or -1.
+func (t *tracer) match(data []byte) int {
+	proto, dst, transport, ok := quotedPacket(data, t.ipv6)
+	if !ok || !dst.Equal(t.dst) || len(transport) < 8 {
+		return -1
+	}
+
+	switch {
+	case t.config.protocol == "icmp" && proto == t.icmp.protocol():
+		if int(binary.BigEndian.Uint16(transport[4:6])) != t.icmp.id {
+			return -1
+		}
+		return int(binary.BigEndian.Uint16(transport[6:8]))
+	case t.config.protocol == "udp" && proto == protocolUDP,
+		t.config.protocol == "tcp" && proto == protocolTCP:
+		srcPort := int(binary.BigEndian.Uint16(transport[0:2]))
+		dstPort := int(binary.BigEndian.Uint16(transport[2:4]))
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.config.flowStable() {
+			return t.matchFlow(proto, srcPort, dstPort, transport)
+		}
+		if key, ok := t.ports[portPair(srcPort, dstPort)]; ok {
+			return key
+		}
+	}
+	return -1
+}
+
+func portPair(srcPort, dstPort int) uint32 {
+	return uint32(srcPort)<<16 | uint32(dstPort)
+}
+
+// pathEnd returns the TTL of the destination or of the router that reported
+// it unreachable, once either has answered.
+func (t *tracer) pathEnd() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	end, found := t.config.maxHops, false
+	for _, probe := range t.probes {
+		if (probe.final || probe.unreachable) && probe.ttl <= end {
+			end, found = probe.ttl, true
+		}
+	}
+	return end, found
+}
+
+// complete reports whether the path end is known and every probe from key
+// first on before it has been answered.
+func (t *tracer) complete(first int) bool {
+	end, found := t.pathEnd()
+	if !found {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, probe := range t.probes[first:] {
+		if probe.ttl <= end && !probe.replied {
+			return false
+		}
+	}
+	return true
+}
+
+// quotedPacket extracts the protocol, destination address and transport
+// header of the original datagram quoted in an ICMP error.
+func quotedPacket(data []byte, ipv6 bool) (int, net.IP, []byte, bool) {
+	if ipv6 {
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return 0, nil, nil, false
+		}
+		return int(data[6]), net.IP(data[24:40]), data[40:], true
+	}
+
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return 0, nil, nil, false
+	}
+	headerLen := int(data[0]&0x0f) * 4
+	if len(data) < headerLen {
+		return 0, nil, nil, false
+	}
+	return int(data[9]), net.IP(data[16:20]), data[headerLen:], true
+}
+
+func icmpTypeNumber(t icmp.Type) int {
+	switch v := t.(type) {
+	case ipv4.ICMPType:
+		return int(v)
+	case ipv6.ICMPType:
+		return int(v)
+	default:
+		return -1
+	}
+}
+
+type traceHop struct {
+	ttl    int
+	probes []traceProbe
+}
+
+// traceHops groups probes by TTL, in sending order, up to the last hop.
+func traceHops(probes []traceProbe, last int) []traceHop {
+	hops := make([]traceHop, last)
+	for i := range hops {
+		hops[i].ttl = i + 1
+	}
+	for _, probe := range probes {
+		if probe.ttl <= last {
+			hops[probe.ttl-1].probes = append(hops[probe.ttl-1].probes, probe)
+		}
+	}
+	return hops
+}
@@ -2,27 +2,26 @@ package checks
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 
 	"ozzus/agent-aeza/internal/domain"
 )
 
-var (
-	tracerouteFromRegexp  = regexp.MustCompile(`(?m)^From ([^\s]+)`)
-	tracerouteBytesRegexp = regexp.MustCompile(`(?m)^\d+ bytes from ([^\s]+)(?: \(([0-9a-fA-F:\.]+)\))?`)
-	tracerouteTimeRegexp  = regexp.MustCompile(`time[=<]([0-9.]+) ms`)
+const (
+	traceDefaultProbes = 3
+	traceMaxProbes     = 10
+	// traceDefaultFlows finds up to three next hops per TTL with 95%
+	// confidence, following the MDA stopping points.
+	traceDefaultFlows = 16
+	traceMaxFlows     = 64
 )
 
 type TracerouteChecker struct {
 	baseMetadata
 	maxHops int
 	timeout time.Duration
+	names   *reverseNames
 }
 
 func NewTracerouteChecker(maxHops int, timeout time.Duration, location, country string) *TracerouteChecker {
@@ -37,6 +36,7 @@ func NewTracerouteChecker(maxHops int, timeout time.Duration, location, country
 		baseMetadata: newBaseMetadata(location, country),
 		maxHops:      maxHops,
 		timeout:      timeout,
+		names:        newReverseNames(),
 	}
 }
 
@@ -46,39 +46,67 @@ func (t *TracerouteChecker) Check(target string, parameters map[string]interface
 		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
 	}
 
-	maxHops := intParam(parameters, "max_hops", t.maxHops)
-	if maxHops <= 0 {
-		maxHops = t.maxHops
+	config, err := t.parseConfig(parameters)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
 	}
 
-	hopTimeout := durationParam(parameters, "timeout", t.timeout)
-	if hopTimeout <= 0 {
-		hopTimeout = t.timeout
+	ipVersion := intParam(parameters, "ip_version", 0)
+	if ipVersion != 0 && ipVersion != 4 && ipVersion != 6 {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: "ip_version must be 4 or 6"}, nil
 	}
 
-	var hops []map[string]interface{}
-	status := domain.StatusSuccess
-	var errText string
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	dst, err := resolveTarget(ctx, host, ipVersion)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
 
-	for ttl := 1; ttl <= maxHops; ttl++ {
-		hop, reached, hopErr := t.runHop(host, ttl, hopTimeout)
-		hop["hop"] = ttl
-		hops = append(hops, hop)
+	tr, err := newTracer(dst, config)
+	if err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+	defer tr.Close()
+
+	if err := tr.trace(context.Background()); err != nil {
+		return &domain.CheckResult{Status: domain.StatusFailed, Error: err.Error()}, nil
+	}
+
+	end, found := tr.pathEnd()
+	hops := traceHops(tr.results(), end)
 
-		if hopErr != nil {
-			status = domain.StatusFailed
-			if errText == "" {
-				errText = hopErr.Error()
+	details := addressDetails{ipdb: t.ipdb}
+	if reverseDNS, dnsTimeout := reverseDNSParams(parameters); reverseDNS {
+		details.names = t.names.lookup(hopAddresses(hops), dnsTimeout)
+	}
+
+	result := make([]map[string]interface{}, 0, len(hops))
+	for i, hop := range hops {
+		entry := hopPayload(hop, details)
+		if config.mode == "multipath" {
+			var next []traceProbe
+			if i+1 < len(hops) {
+				next = hops[i+1].probes
 			}
+			entry["addresses"] = multipathAddresses(hop.probes, next, details)
 		}
+		result = append(result, entry)
+	}
 
-		if reached {
-			break
-		}
+	status := domain.StatusSuccess
+	var errText string
+	if !found {
+		status = domain.StatusFailed
+		errText = fmt.Sprintf("destination not reached within %d hops", config.maxHops)
+	} else if last := hops[len(hops)-1]; !reachedDestination(last) {
+		status = domain.StatusFailed
+		errText = unreachableError(last)
 	}
 
 	payload := map[string]interface{}{
-		"traceroute": hops,
+		"traceroute": result,
 	}
 
 	return &domain.CheckResult{
@@ -88,56 +116,230 @@ func (t *TracerouteChecker) Check(target string, parameters map[string]interface
 	}, nil
 }
 
-func (t *TracerouteChecker) runHop(target string, ttl int, timeout time.Duration) (map[string]interface{}, bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Second)
-	defer cancel()
+// parseConfig reads max_hops, timeout, probes, protocol, port, mode and
+// flows. timeout is how long to wait for replies once all probes are sent.
+// In multipath mode one probe per flow replaces probes.
+func (t *TracerouteChecker) parseConfig(parameters map[string]interface{}) (traceConfig, error) {
+	protocol, port, err := traceProtocolParams(parameters)
+	if err != nil {
+		return traceConfig{}, err
+	}
+
+	mode := lowerStringParam(parameters, "mode", "classic")
+	switch mode {
+	case "classic", "paris", "multipath":
+	default:
+		return traceConfig{}, fmt.Errorf("unsupported mode: %s", mode)
+	}
+
+	maxHops, err := traceMaxHopsParam(parameters, t.maxHops)
+	if err != nil {
+		return traceConfig{}, err
+	}
+
+	config := traceConfig{
+		protocol: protocol,
+		port:     port,
+		mode:     mode,
+		maxHops:  maxHops,
+		probes:   intParam(parameters, "probes", traceDefaultProbes),
+		timeout:  durationParam(parameters, "timeout", t.timeout),
+	}
+
+	if config.timeout <= 0 {
+		config.timeout = t.timeout
+	}
+	if config.probes <= 0 {
+		config.probes = traceDefaultProbes
+	}
+	if config.probes > traceMaxProbes {
+		return config, fmt.Errorf("probes must be at most %d", traceMaxProbes)
+	}
+	if mode == "multipath" {
+		config.probes = intParam(parameters, "flows", traceDefaultFlows)
+		if config.probes <= 0 {
+			config.probes = traceDefaultFlows
+		}
+		if config.probes > traceMaxFlows {
+			return config, fmt.Errorf("flows must be at most %d", traceMaxFlows)
+		}
+	}
 
-	deadline := int(timeout.Seconds())
-	if deadline <= 0 {
-		deadline = 1
+	return config, nil
+}
+
+// traceProtocolParams reads the probe protocol and destination port shared
+// by traceroute and mtr. TCP probes go to port 80 by default, UDP probes
+// without a port use classic incrementing ports.
+func traceProtocolParams(parameters map[string]interface{}) (string, int, error) {
+	protocol := lowerStringParam(parameters, "protocol", "icmp")
+	port := intParam(parameters, "port", 0)
+	if port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port: %d", port)
+	}
+
+	switch protocol {
+	case "icmp":
+		return protocol, 0, nil
+	case "udp":
+		return protocol, port, nil
+	case "tcp":
+		if port == 0 {
+			port = 80
+		}
+		return protocol, port, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+}
+
+// traceMaxHopsParam reads max_hops for traceroute and mtr. Values up to 0
+// select defaultHops.
+func traceMaxHopsParam(parameters map[string]interface{}, defaultHops int) (int, error) {
+	maxHops := intParam(parameters, "max_hops", defaultHops)
+	if maxHops <= 0 {
+		return defaultHops, nil
+	}
+	if maxHops > 255 {
+		return 0, fmt.Errorf("max_hops must be at most 255")
 	}
+	return maxHops, nil
+}
+
+// addressDetails adds optional fields to every reported address: hostname
+// when reverse DNS is enabled and ipInfo when the IP database knows it.
+type addressDetails struct {
+	names map[string]string
+	ipdb  *IPDatabase
+}
 
-	args := []string{"-n", "-c", "1", "-t", strconv.Itoa(ttl), "-W", fmt.Sprintf("%d", deadline), target}
-	cmd := exec.CommandContext(ctx, "ping", args...)
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+func (d addressDetails) add(entry map[string]interface{}, ip string) {
+	if d.names != nil {
+		entry["hostname"] = d.names[ip]
+	}
+	if info, ok := d.ipdb.lookup(ip); ok {
+		entry["ipInfo"] = info.payload()
+	}
+}
 
-	hop := map[string]interface{}{
+// hopPayload describes one TTL.
+func hopPayload(hop traceHop, details addressDetails) map[string]interface{} {
+	entry := map[string]interface{}{
+		"hop":  hop.ttl,
 		"ip":   "*",
 		"time": "timeout",
 	}
 
-	if matches := tracerouteBytesRegexp.FindStringSubmatch(outputStr); len(matches) >= 2 {
-		if len(matches) >= 3 && matches[2] != "" {
-			hop["ip"] = matches[2]
+	probes := make([]map[string]interface{}, 0, len(hop.probes))
+	for _, probe := range hop.probes {
+		if !probe.replied {
+			probes = append(probes, map[string]interface{}{"timeout": true})
+			continue
+		}
+		ip := probe.from.String()
+		if entry["ip"] == "*" {
+			entry["ip"] = ip
+			entry["time"] = formatMilliseconds(probe.rtt)
+		}
+		result := map[string]interface{}{
+			"ip":   ip,
+			"time": durationMillis(probe.rtt),
+		}
+		details.add(result, ip)
+		if probe.portState != "" {
+			result["state"] = probe.portState
 		} else {
-			hop["ip"] = strings.Trim(matches[1], "<>")
+			result["icmpType"] = probe.icmpType
+			result["icmpCode"] = probe.icmpCode
+		}
+		probes = append(probes, result)
+	}
+	entry["probes"] = probes
+	details.add(entry, entry["ip"].(string))
+
+	return entry
+}
+
+// multipathAddresses lists every address that answered at one TTL with the
+// flows that reached it, and the addresses the same flows reached one hop
+// further, which describes the links between load-balanced paths.
+func multipathAddresses(probes, next []traceProbe, details addressDetails) []map[string]interface{} {
+	nextByFlow := make(map[int]string)
+	for _, probe := range next {
+		if probe.replied {
+			nextByFlow[probe.flow] = probe.from.String()
+		}
+	}
+
+	var order []string
+	flows := make(map[string][]int)
+	links := make(map[string][]string)
+	for _, probe := range probes {
+		if !probe.replied {
+			continue
 		}
-		if timeMatch := tracerouteTimeRegexp.FindStringSubmatch(outputStr); len(timeMatch) == 2 {
-			hop["time"] = fmt.Sprintf("%s ms", timeMatch[1])
+		ip := probe.from.String()
+		if _, ok := flows[ip]; !ok {
+			order = append(order, ip)
+		}
+		flows[ip] = append(flows[ip], probe.flow)
+
+		if nextIP, ok := nextByFlow[probe.flow]; ok {
+			links[ip] = appendUnique(links[ip], nextIP)
 		}
-		return hop, true, nil
 	}
 
-	if matches := tracerouteFromRegexp.FindStringSubmatch(outputStr); len(matches) == 2 {
-		hop["ip"] = strings.Trim(matches[1], "<>")
+	result := make([]map[string]interface{}, 0, len(order))
+	for _, ip := range order {
+		address := map[string]interface{}{
+			"ip":    ip,
+			"flows": flows[ip],
+			"next":  append([]string{}, links[ip]...),
+		}
+		details.add(address, ip)
+		result = append(result, address)
 	}
+	return result
+}
 
-	if timeMatch := tracerouteTimeRegexp.FindStringSubmatch(outputStr); len(timeMatch) == 2 {
-		hop["time"] = fmt.Sprintf("%s ms", timeMatch[1])
+// hopAddresses lists the addresses of all answered probes.
+func hopAddresses(hops []traceHop) []string {
+	var addrs []string
+	for _, hop := range hops {
+		for _, probe := range hop.probes {
+			if probe.replied {
+				addrs = append(addrs, probe.from.String())
+			}
+		}
 	}
+	return addrs
+}
 
-	if ctx.Err() != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
-		return hop, false, ctx.Err()
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
 	}
+	return append(values, value)
+}
 
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() != 0 {
-			return hop, false, err
+func reachedDestination(hop traceHop) bool {
+	for _, probe := range hop.probes {
+		if probe.final {
+			return true
 		}
 	}
+	return false
+}
 
-	return hop, false, nil
+func unreachableError(hop traceHop) string {
+	for _, probe := range hop.probes {
+		if probe.unreachable {
+			return fmt.Sprintf("destination unreachable (code %d) reported by %s", probe.icmpCode, probe.from)
+		}
+	}
+	return "destination unreachable"
 }
 
 func (t *TracerouteChecker) Type() domain.TaskType {
@@ -0,0 +1,271 @@
+package checks
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// flowDstPort is the destination port of flow-stable UDP and TCP probes.
+func (t *tracer) flowDstPort() int {
+	if t.config.port > 0 {
+		return t.config.port
+	}
+	return traceBasePort
+}
+
+// flowPort returns the source port that identifies flow, reserving it on
+// first use. Load balancers hash on the address and port tuple, so all
+// probes of a flow take the same path. The reserving socket also receives
+// UDP answers; probes are written to the raw socket so that the kernel does
+// not offload their checksums.
+func (t *tracer) flowPort(flow int) (int, error) {
+	t.mu.Lock()
+	port, ok := t.flows[flow]
+	t.mu.Unlock()
+	if ok {
+		return port, nil
+	}
+
+	var socket io.Closer
+	if t.config.protocol == "udp" {
+		conn, err := net.ListenUDP(t.network("udp"), nil)
+		if err != nil {
+			return 0, err
+		}
+		port, socket = conn.LocalAddr().(*net.UDPAddr).Port, conn
+
+		t.workers.Add(1)
+		go t.receiveFlowUDP(flow, conn)
+	} else {
+		listener, err := net.ListenTCP(t.network("tcp"), &net.TCPAddr{IP: t.src})
+		if err != nil {
+			return 0, err
+		}
+		port, socket = listener.Addr().(*net.TCPAddr).Port, listener
+	}
+
+	t.mu.Lock()
+	t.flows[flow] = port
+	t.sockets = append(t.sockets, socket)
+	t.mu.Unlock()
+	return port, nil
+}
+
+// hasFlowPort reports whether port is the source port of one of the flows.
+// t.mu must be held.
+func (t *tracer) hasFlowPort(port int) bool {
+	for _, flowPort := range t.flows {
+		if flowPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// sendFlowUDP sends a probe with the ports of flow. The key goes into the
+// payload, so each probe has its own UDP checksum, which the ICMP error
+// quotes.
+func (t *tracer) sendFlowUDP(key, ttl, flow int) error {
+	srcPort, err := t.flowPort(flow)
+	if err != nil {
+		return err
+	}
+	if err := setTTL(t.raw, t.ipv6, ttl); err != nil {
+		return err
+	}
+
+	segment := make([]byte, 8+tracePayloadSize)
+	binary.BigEndian.PutUint16(segment[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(segment[2:4], uint16(t.flowDstPort()))
+	binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+	binary.BigEndian.PutUint16(segment[8:10], uint16(key))
+	sum := transportChecksum(t.src, t.dst, protocolUDP, segment)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[6:8], sum)
+
+	t.mu.Lock()
+	t.sums[portPair(srcPort, int(sum))] = key
+	t.mu.Unlock()
+	t.markSent(key, 0, 0)
+
+	_, err = t.raw.WriteTo(segment, &net.IPAddr{IP: t.dst})
+	return err
+}
+
+// receiveFlowUDP waits for UDP answers from the destination. They do not
+// identify the probe, so each one is credited to the lowest unanswered TTL
+// of the flow beyond the routers that already answered, among the probes
+// that have not timed out.
+func (t *tracer) receiveFlowUDP(flow int, conn *net.UDPConn) {
+	defer t.workers.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		_, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(t.dst) {
+			continue
+		}
+		at := time.Now()
+
+		t.mu.Lock()
+		key, reached := -1, 0
+		for _, probe := range t.probes {
+			if probe.flow == flow && probe.replied && !probe.final && probe.ttl > reached {
+				reached = probe.ttl
+			}
+		}
+		for i, probe := range t.probes {
+			pending := !probe.replied && at.Sub(probe.sent) <= t.config.timeout
+			if probe.flow == flow && pending && probe.ttl > reached && (key < 0 || probe.ttl < t.probes[key].ttl) {
+				key = i
+			}
+		}
+		t.mu.Unlock()
+
+		t.answer(key, traceProbe{from: t.dst, icmpType: -1, final: true, portState: "open"}, at)
+	}
+}
+
+// sendFlowTCP writes a SYN with the ports of flow to the raw socket. The
+// key is the sequence number, which both ICMP errors and the destination's
+// SYN-ACK or RST carry back.
+func (t *tracer) sendFlowTCP(key, ttl, flow int) error {
+	srcPort, err := t.flowPort(flow)
+	if err != nil {
+		return err
+	}
+	if err := setTTL(t.raw, t.ipv6, ttl); err != nil {
+		return err
+	}
+
+	segment := make([]byte, 20)
+	binary.BigEndian.PutUint16(segment[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(segment[2:4], uint16(t.config.port))
+	binary.BigEndian.PutUint32(segment[4:8], uint32(key))
+	segment[12] = 5 << 4
+	segment[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(segment[14:16], 0xffff)
+	binary.BigEndian.PutUint16(segment[16:18], transportChecksum(t.src, t.dst, protocolTCP, segment))
+
+	t.markSent(key, 0, 0)
+	_, err = t.raw.WriteTo(segment, &net.IPAddr{IP: t.dst})
+	return err
+}
+
+// receiveTCP reads the destination's answers to raw SYN probes. The kernel
+// resets the half-open connections itself.
+func (t *tracer) receiveTCP() {
+	defer t.workers.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := t.raw.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		addr, ok := from.(*net.IPAddr)
+		if !ok || !addr.IP.Equal(t.dst) || n < 20 {
+			continue
+		}
+
+		segment := buf[:n]
+		srcPort := int(binary.BigEndian.Uint16(segment[0:2]))
+		dstPort := int(binary.BigEndian.Uint16(segment[2:4]))
+		t.mu.Lock()
+		ours := srcPort == t.config.port && t.hasFlowPort(dstPort)
+		t.mu.Unlock()
+		if !ours {
+			continue
+		}
+
+		var state string
+		switch flags := segment[13]; {
+		case flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK:
+			state = "open"
+		case flags&tcpFlagRST != 0:
+			state = "closed"
+		default:
+			continue
+		}
+
+		key := int(binary.BigEndian.Uint32(segment[8:12]) - 1)
+		t.answer(key, traceProbe{from: t.dst, icmpType: -1, final: true, portState: state}, time.Now())
+	}
+}
+
+// matchFlow returns the key of a quoted flow-stable UDP or TCP probe, or -1.
+// t.mu must be held.
+func (t *tracer) matchFlow(proto, srcPort, dstPort int, transport []byte) int {
+	if dstPort != t.flowDstPort() || !t.hasFlowPort(srcPort) {
+		return -1
+	}
+	if proto == protocolTCP {
+		return int(binary.BigEndian.Uint32(transport[4:8]))
+	}
+	if key, ok := t.sums[portPair(srcPort, int(binary.BigEndian.Uint16(transport[6:8])))]; ok {
+		return key
+	}
+	return -1
+}
+
+// echoChecksumPad returns the first payload word of echo request seq that
+// keeps the ICMP checksum, which load balancers hash, the same for all
+// probes of flow: seq plus the pad always sums to flow.
+func echoChecksumPad(seq, flow int) uint16 {
+	sum := uint32(flow&0xffff) + uint32(^uint16(seq))
+	return uint16(sum&0xffff + sum>>16)
+}
+
+// transportChecksum returns the TCP or UDP checksum of segment, including
+// the pseudo header.
+func transportChecksum(src, dst net.IP, proto int, segment []byte) uint16 {
+	var sum uint32
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		sum = onesSum(sum, src4)
+		sum = onesSum(sum, dst4)
+	} else {
+		sum = onesSum(sum, src.To16())
+		sum = onesSum(sum, dst.To16())
+	}
+	sum += uint32(proto) + uint32(len(segment))
+	sum = onesSum(sum, segment)
+
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func onesSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// sourceAddress returns the local address the kernel would send to dst from.
+// Connecting a UDP socket sends nothing.
+func sourceAddress(dst net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: traceBasePort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
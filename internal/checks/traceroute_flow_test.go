@@ -0,0 +1,95 @@
+package checks
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestEchoChecksumPad(t *testing.T) {
+	for _, flow := range []int{0, 1, 0x1234, 0xfffe, 0xffff} {
+		var want uint16
+		for seq := 0; seq <= 0xffff; seq += 257 {
+			pad := make([]byte, 2, 16)
+			binary.BigEndian.PutUint16(pad, echoChecksumPad(seq, flow))
+			message := icmp.Message{
+				Type: ipv4.ICMPTypeEcho,
+				Body: &icmp.Echo{ID: 0x4242, Seq: seq, Data: append(pad, "payload"...)},
+			}
+			b, err := message.Marshal(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := binary.BigEndian.Uint16(b[2:4])
+			if seq == 0 {
+				want = sum
+				continue
+			}
+			if sum != want {
+				t.Fatalf("flow %#x: seq %d has checksum %#04x, seq 0 has %#04x", flow, seq, sum, want)
+			}
+		}
+	}
+}
+
+func TestTransportChecksum(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst string
+		proto    int
+		segment  []byte
+		// offset of the checksum field in segment
+		offset int
+		want   uint16
+	}{
+		{
+			// UDP 1024 -> 53 carrying "abc", checksum computed by hand.
+			name:    "udp4",
+			src:     "192.0.2.1",
+			dst:     "198.51.100.2",
+			proto:   protocolUDP,
+			segment: []byte{0x04, 0x00, 0x00, 0x35, 0x00, 0x0b, 0x00, 0x00, 'a', 'b', 'c'},
+			offset:  6,
+			want:    0x4b09,
+		},
+		{
+			name:    "udp6 even length",
+			src:     "2001:db8::1",
+			dst:     "2001:db8::2",
+			proto:   protocolUDP,
+			segment: []byte{0x82, 0x9a, 0x82, 0x9b, 0x00, 0x0c, 0x00, 0x00, 1, 2, 3, 4},
+			offset:  6,
+		},
+		{
+			name:  "tcp4 syn",
+			src:   "10.0.0.1",
+			dst:   "10.0.0.2",
+			proto: protocolTCP,
+			segment: []byte{
+				0xc0, 0x00, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+				0x50, 0x02, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+			},
+			offset: 16,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := net.ParseIP(tt.src), net.ParseIP(tt.dst)
+			sum := transportChecksum(src, dst, tt.proto, tt.segment)
+			if tt.want != 0 && sum != tt.want {
+				t.Fatalf("checksum %#04x, want %#04x", sum, tt.want)
+			}
+
+			// A segment carrying its checksum sums to zero.
+			filled := append([]byte(nil), tt.segment...)
+			binary.BigEndian.PutUint16(filled[tt.offset:], sum)
+			if verify := transportChecksum(src, dst, tt.proto, filled); verify != 0 {
+				t.Fatalf("verification sum %#04x, want 0", verify)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package checks
+
+import (
+	"os"
+	"syscall"
+)
+
+// tcpProbeControl sets the TTL of a TCP probe socket and binds it before
+// connect, so its source port is known before the SYN leaves.
+func tcpProbeControl(ttl int, ipv6 bool, bound func(port int)) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			level, option := syscall.IPPROTO_IP, syscall.IP_TTL
+			var local syscall.Sockaddr = &syscall.SockaddrInet4{}
+			if ipv6 {
+				level, option = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+				local = &syscall.SockaddrInet6{}
+			}
+
+			if err := syscall.SetsockoptInt(int(fd), level, option, ttl); err != nil {
+				sockErr = os.NewSyscallError("setsockopt", err)
+				return
+			}
+			if err := syscall.Bind(int(fd), local); err != nil {
+				sockErr = os.NewSyscallError("bind", err)
+				return
+			}
+			name, err := syscall.Getsockname(int(fd))
+			if err != nil {
+				sockErr = os.NewSyscallError("getsockname", err)
+				return
+			}
+
+			switch sa := name.(type) {
+			case *syscall.SockaddrInet4:
+				bound(sa.Port)
+			case *syscall.SockaddrInet6:
+				bound(sa.Port)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package checks
+
+import (
+	"errors"
+	"syscall"
+)
+
+func tcpProbeControl(ttl int, ipv6 bool, bound func(port int)) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, _ syscall.RawConn) error {
+		return errors.New("tcp traceroute is only supported on linux")
+	}
+}
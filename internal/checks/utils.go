@@ -12,6 +12,7 @@ import (
 type baseMetadata struct {
 	location string
 	country  string
+	ipdb     *IPDatabase
 }
 
 func newBaseMetadata(location, country string) baseMetadata {
@@ -25,6 +26,20 @@ func newBaseMetadata(location, country string) baseMetadata {
 	return baseMetadata{location: location, country: country}
 }
 
+// SetIPDatabase enables ASN and geolocation details for reported addresses.
+func (b *baseMetadata) SetIPDatabase(db *IPDatabase) {
+	b.ipdb = db
+}
+
+// ipInfo returns the ipInfo payload of ip, or nil when it is unknown.
+func (b baseMetadata) ipInfo(ip string) map[string]interface{} {
+	info, ok := b.ipdb.lookup(ip)
+	if !ok {
+		return nil
+	}
+	return info.payload()
+}
+
 func (b baseMetadata) locationValue(params map[string]interface{}) string {
 	location := stringParam(params, "location", b.location)
 	if location == "" {
@@ -71,6 +86,45 @@ func lowerStringParam(params map[string]interface{}, key, fallback string) strin
 	return strings.ToLower(stringParam(params, key, fallback))
 }
 
+// stringListParam accepts a JSON array or a single scalar value.
+func stringListParam(params map[string]interface{}, key string) []string {
+	if params == nil {
+		return nil
+	}
+
+	switch v := params[key].(type) {
+	case nil:
+		return nil
+	case []string:
+		return v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			result = append(result, fmt.Sprintf("%v", item))
+		}
+		return result
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	default:
+		return []string{fmt.Sprintf("%v", v)}
+	}
+}
+
+func mapParam(params map[string]interface{}, key string) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+
+	if value, ok := params[key].(map[string]interface{}); ok {
+		return value
+	}
+
+	return nil
+}
+
 func intParam(params map[string]interface{}, key string, fallback int) int {
 	if params == nil {
 		return fallback
@@ -98,6 +152,29 @@ func intParam(params map[string]interface{}, key string, fallback int) int {
 	return fallback
 }
 
+func boolParam(params map[string]interface{}, key string, fallback bool) bool {
+	if params == nil {
+		return fallback
+	}
+
+	if value, ok := params[key]; ok {
+		switch v := value.(type) {
+		case bool:
+			return v
+		case int:
+			return v != 0
+		case float64:
+			return v != 0
+		case string:
+			if parsed, err := strconv.ParseBool(v); err == nil {
+				return parsed
+			}
+		}
+	}
+
+	return fallback
+}
+
 func durationParam(params map[string]interface{}, key string, fallback time.Duration) time.Duration {
 	if params == nil {
 		return fallback
@@ -137,6 +214,13 @@ func formatMilliseconds(d time.Duration) string {
 	return fmt.Sprintf("%.1f ms", float64(d.Microseconds())/1000.0)
 }
 
+func durationMillis(d time.Duration) float64 {
+	if d < 0 {
+		d = 0
+	}
+	return float64(d.Microseconds()) / 1000.0
+}
+
 func formatTTL(d time.Duration) string {
 	if d <= 0 {
 		return "N/A"
@@ -165,6 +249,19 @@ func formatTTL(d time.Duration) string {
 	return strings.Join(parts, " ")
 }
 
+func remoteIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.IPAddr:
+		return a.IP.String()
+	default:
+		return addr.String()
+	}
+}
+
 func normalizeHostname(target string) (string, error) {
 	trimmed := strings.TrimSpace(target)
 	if trimmed == "" {
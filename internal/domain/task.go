@@ -7,11 +7,15 @@ import "time"
 type TaskType string
 
 const (
-	TaskTypeHTTP       TaskType = "http"
-	TaskTypePing       TaskType = "ping"
-	TaskTypeTCP        TaskType = "tcp"
-	TaskTypeTraceroute TaskType = "traceroute"
-	TaskTypeDNS        TaskType = "dns_lookup"
+	TaskTypeHTTP            TaskType = "http"
+	TaskTypePing            TaskType = "ping"
+	TaskTypeTCP             TaskType = "tcp"
+	TaskTypeTraceroute      TaskType = "traceroute"
+	TaskTypeDNS             TaskType = "dns_lookup"
+	TaskTypeSSL             TaskType = "ssl"
+	TaskTypeHTTPTransaction TaskType = "http_transaction"
+	TaskTypeMTR             TaskType = "mtr"
+	TaskTypePMTU            TaskType = "pmtu"
 )
 
 //типы DNS записей
@@ -19,11 +23,20 @@ const (
 type DNSRecordType string
 
 const (
-	DNSRecordA    DNSRecordType = "A"
-	DNSRecordAAAA DNSRecordType = "AAAA"
-	DNSRecordMX   DNSRecordType = "MX"
-	DNSRecordNS   DNSRecordType = "NS"
-	DNSRecordTXT  DNSRecordType = "TXT"
+	DNSRecordA      DNSRecordType = "A"
+	DNSRecordAAAA   DNSRecordType = "AAAA"
+	DNSRecordMX     DNSRecordType = "MX"
+	DNSRecordNS     DNSRecordType = "NS"
+	DNSRecordTXT    DNSRecordType = "TXT"
+	DNSRecordCNAME  DNSRecordType = "CNAME"
+	DNSRecordSOA    DNSRecordType = "SOA"
+	DNSRecordSRV    DNSRecordType = "SRV"
+	DNSRecordCAA    DNSRecordType = "CAA"
+	DNSRecordPTR    DNSRecordType = "PTR"
+	DNSRecordDS     DNSRecordType = "DS"
+	DNSRecordDNSKEY DNSRecordType = "DNSKEY"
+	DNSRecordHTTPS  DNSRecordType = "HTTPS"
+	DNSRecordSVCB   DNSRecordType = "SVCB"
 )
 
 type Task struct {